@@ -5,43 +5,92 @@ import (
 	"time"
 )
 
+const (
+	SignatureExt = ".asc"
+	ManifestExt  = ".manifest"
+	DigestExt    = ".sha512"
+	YankedExt    = ".yanked"
+)
+
 type Aci struct {
-	Name    string
-	Details []AciDetails
+	Name    string       `json:"name"`
+	Details []AciDetails `json:"details"`
 }
 
 type AciDetails struct {
-	Version string
-	OS      string
-	Arch    string
-	Signed  bool
-	LastMod string
+	Version      string    `json:"version"`
+	OS           string    `json:"os"`
+	Arch         string    `json:"arch"`
+	File         string    `json:"file"`
+	Signed       bool      `json:"signed"`
+	Manifest     bool      `json:"manifest"`
+	Yanked       bool      `json:"yanked"`
+	LastMod      string    `json:"-"`
+	LastModified time.Time `json:"last_modified"`
+	Size         int64     `json:"size"`
+	Digest       string    `json:"digest,omitempty"`
+	Signer       string    `json:"signer,omitempty"`
+	Uploader     string    `json:"uploader,omitempty"`
 }
 
 type RawFile struct {
 	Name string
 	Date time.Time
+	Size int64
+	// Digest is the digest of the ACI, if the listing knows it.
+	Digest string
+
+	// Content is only expected for manifests, it helps parsing the names
+	// of the images they describe, and for digests, the ones of the ACIs
+	// when the listing doesn't know them.
+	Content []byte
+}
+
+type InvalidFile struct {
+	Name  string `json:"name"`
+	Error string `json:"error"`
 }
 
-func BuildAciList(files []RawFile) []Aci {
+func BuildAciList(files []RawFile) ([]Aci, []InvalidFile) {
 	var (
 		r             = []Aci{}
+		invalid       = []InvalidFile{}
 		aciDetails    = map[string][]AciDetails{}
 		gatheredFiles = map[string]struct {
-			aci *RawFile
-			asc *RawFile
+			aci      *RawFile
+			asc      *RawFile
+			manifest *RawFile
+			digest   *RawFile
+			yanked   bool
 		}{}
 	)
 
-	for _, f := range files {
-		if strings.HasSuffix(f.Name, ".asc") {
-			v := gatheredFiles[strings.TrimSuffix(f.Name, ".asc")]
-			v.asc = &f
+	for i := range files {
+		f := &files[i]
+		switch {
+		case strings.HasSuffix(f.Name, SignatureExt):
+			v := gatheredFiles[strings.TrimSuffix(f.Name, SignatureExt)]
+			v.asc = f
+
+			gatheredFiles[strings.TrimSuffix(f.Name, SignatureExt)] = v
+		case strings.HasSuffix(f.Name, YankedExt):
+			v := gatheredFiles[strings.TrimSuffix(f.Name, YankedExt)]
+			v.yanked = true
+
+			gatheredFiles[strings.TrimSuffix(f.Name, YankedExt)] = v
+		case strings.HasSuffix(f.Name, ManifestExt):
+			v := gatheredFiles[strings.TrimSuffix(f.Name, ManifestExt)]
+			v.manifest = f
+
+			gatheredFiles[strings.TrimSuffix(f.Name, ManifestExt)] = v
+		case strings.HasSuffix(f.Name, DigestExt):
+			v := gatheredFiles[strings.TrimSuffix(f.Name, DigestExt)]
+			v.digest = f
 
-			gatheredFiles[strings.TrimSuffix(f.Name, ".asc")] = v
-		} else {
+			gatheredFiles[strings.TrimSuffix(f.Name, DigestExt)] = v
+		default:
 			v := gatheredFiles[f.Name]
-			v.aci = &f
+			v.aci = f
 
 			gatheredFiles[f.Name] = v
 		}
@@ -52,28 +101,46 @@ func BuildAciList(files []RawFile) []Aci {
 			continue
 		}
 
-		tokens := strings.Split(name, "-")
-		if len(tokens) != 4 {
-			continue
+		var m *Manifest
+
+		if files.manifest != nil && len(files.manifest.Content) > 0 {
+			var err error
+
+			if m, err = ParseManifest(files.manifest.Content); err != nil {
+				invalid = append(
+					invalid,
+					InvalidFile{files.manifest.Name, err.Error()},
+				)
+			}
 		}
 
-		tokens1 := strings.Split(tokens[3], ".")
-		if len(tokens1) != 2 {
+		img, err := ParseFilenameWithManifest(name, m)
+
+		if err != nil {
+			invalid = append(invalid, InvalidFile{name, err.Error()})
 			continue
 		}
 
-		if tokens1[1] != "aci" {
-			continue
+		digest := files.aci.Digest
+
+		if digest == "" && files.digest != nil {
+			digest = strings.TrimSpace(string(files.digest.Content))
 		}
 
-		aciDetails[tokens[0]] = append(
-			aciDetails[tokens[0]],
+		aciDetails[img.Name] = append(
+			aciDetails[img.Name],
 			AciDetails{
-				Version: tokens[1],
-				OS:      tokens[2],
-				Arch:    tokens1[0],
-				Signed:  files.asc != nil,
-				LastMod: files.aci.Date.Format(time.RubyDate),
+				Version:      img.Version,
+				OS:           img.OS,
+				Arch:         img.Arch,
+				File:         name,
+				Signed:       files.asc != nil,
+				Manifest:     files.manifest != nil,
+				Yanked:       files.yanked,
+				LastMod:      files.aci.Date.Format(time.RubyDate),
+				LastModified: files.aci.Date,
+				Size:         files.aci.Size,
+				Digest:       digest,
 			},
 		)
 	}
@@ -82,5 +149,7 @@ func BuildAciList(files []RawFile) []Aci {
 		r = append(r, Aci{name, details})
 	}
 
-	return r
+	SortAcis(r)
+
+	return r, invalid
 }
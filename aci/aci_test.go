@@ -7,48 +7,52 @@ import (
 )
 
 func TestBuildAciList(t *testing.T) {
-	if l := len(BuildAciList([]RawFile{})); l != 0 {
-		t.Errorf("Wrong len by default: %d", l)
+	if l, _ := BuildAciList([]RawFile{}); len(l) != 0 {
+		t.Errorf("Wrong len by default: %d", len(l))
 	}
 
 	date := "Mon Jan 02 15:04:05 -0700 2006"
 	d, _ := time.Parse(time.RubyDate, date)
 
 	data := []RawFile{
-		RawFile{"foo.com/bar-latest-linux-amd64.aci", d},
-		RawFile{"foo.com/bar-latest-linux-amd64.aci.asc", d},
-		RawFile{"foo.com/bar-0.0.4-linux-amd64.aci", d},
-		RawFile{"foo.com/buz-latest-linux-amd64.aci", d},
-		RawFile{"foo.com/fiz-0.0.1-linux-amd64.aci", d},
-		RawFile{"foo.com/fuz-wrong", d},
+		RawFile{Name: "foo.com/bar-latest-linux-amd64.aci", Date: d},
+		RawFile{Name: "foo.com/bar-latest-linux-amd64.aci.asc", Date: d},
+		RawFile{Name: "foo.com/bar-0.0.4-linux-amd64.aci", Date: d},
+		RawFile{Name: "foo.com/buz-latest-linux-amd64.aci", Date: d},
+		RawFile{Name: "foo.com/fiz-0.0.1-linux-amd64.aci", Date: d},
+		RawFile{Name: "foo.com/fuz-wrong", Date: d},
 	}
 
 	e := []Aci{
 		Aci{
 			"foo.com/bar",
 			[]AciDetails{
-				AciDetails{"latest", "linux", "amd64", true, date},
-				AciDetails{"0.0.4", "linux", "amd64", false, date},
+				AciDetails{Version: "0.0.4", OS: "linux", Arch: "amd64", File: "foo.com/bar-0.0.4-linux-amd64.aci", Signed: false, LastMod: date, LastModified: d},
+				AciDetails{Version: "latest", OS: "linux", Arch: "amd64", File: "foo.com/bar-latest-linux-amd64.aci", Signed: true, LastMod: date, LastModified: d},
 			},
 		},
 		Aci{
 			"foo.com/fiz",
 			[]AciDetails{
-				AciDetails{"0.0.1", "linux", "amd64", false, date},
+				AciDetails{Version: "0.0.1", OS: "linux", Arch: "amd64", File: "foo.com/fiz-0.0.1-linux-amd64.aci", Signed: false, LastMod: date, LastModified: d},
 			},
 		},
 		Aci{
 			"foo.com/buz",
 			[]AciDetails{
-				AciDetails{"latest", "linux", "amd64", false, date},
+				AciDetails{Version: "latest", OS: "linux", Arch: "amd64", File: "foo.com/buz-latest-linux-amd64.aci", Signed: false, LastMod: date, LastModified: d},
 			},
 		},
 	}
 
-	test := BuildAciList(data)
+	test, invalid := BuildAciList(data)
 	if !containsAll(test, e...) {
 		t.Errorf("Wrong parsing: %+v", test)
 	}
+
+	if len(invalid) != 1 || invalid[0].Name != "foo.com/fuz-wrong" {
+		t.Errorf("Wrong invalid files: %+v", invalid)
+	}
 }
 
 func containsAll(vs []Aci, elts ...Aci) bool {
@@ -74,3 +78,148 @@ func containsAll(vs []Aci, elts ...Aci) bool {
 
 	return res
 }
+
+func TestSortAcis(t *testing.T) {
+	d := time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC)
+	details := func(versions ...string) []AciDetails {
+		res := []AciDetails{}
+
+		for i, v := range versions {
+			res = append(res, AciDetails{Version: v, OS: "linux", Arch: "amd64", LastModified: d.Add(time.Duration(i) * time.Hour)})
+		}
+
+		return res
+	}
+
+	acis := []Aci{
+		Aci{"foo.com/fiz", details("latest", "1.10.0", "1.2.0", "stable", "1.2.0-rc.1", "v1.2.0")},
+		Aci{"foo.com/bar", details("0.1.0")},
+	}
+
+	SortAcis(acis)
+
+	if acis[0].Name != "foo.com/bar" {
+		t.Errorf("Wrong image order: %+v", acis)
+	}
+
+	versions := []string{}
+
+	for _, d := range acis[1].Details {
+		versions = append(versions, d.Version)
+	}
+
+	if e := []string{"1.2.0-rc.1", "1.2.0", "v1.2.0", "1.10.0", "latest", "stable"}; !reflect.DeepEqual(versions, e) {
+		t.Errorf("Wrong version order: %v", versions)
+	}
+}
+
+func TestLatest(t *testing.T) {
+	d := time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC)
+	a := Aci{
+		"foo.com/bar",
+		[]AciDetails{
+			AciDetails{Version: "1.0.0", OS: "linux", Arch: "amd64", File: "foo.com/bar-1.0.0-linux-amd64.aci"},
+			AciDetails{Version: "1.2.0", OS: "linux", Arch: "amd64", File: "foo.com/bar-1.2.0-linux-amd64.aci", Yanked: true},
+			AciDetails{Version: "1.1.0", OS: "linux", Arch: "amd64", File: "foo.com/bar-1.1.0-linux-amd64.aci", LastModified: d},
+			AciDetails{Version: "v1.1.0", OS: "linux", Arch: "amd64", File: "foo.com/bar-v1.1.0-linux-amd64.aci", LastModified: d.Add(time.Hour)},
+			AciDetails{Version: "2.0.0-beta", OS: "linux", Arch: "amd64", File: "foo.com/bar-2.0.0-beta-linux-amd64.aci"},
+			AciDetails{Version: "3.0.0", OS: "linux", Arch: "arm64", File: "foo.com/bar-3.0.0-linux-arm64.aci"},
+			AciDetails{Version: "latest", OS: "linux", Arch: "amd64", File: "foo.com/bar-latest-linux-amd64.aci"},
+		},
+	}
+
+	for _, tt := range []struct {
+		arch       string
+		preRelease bool
+		out        string
+	}{
+		{"amd64", false, "v1.1.0"},
+		{"amd64", true, "2.0.0-beta"},
+		{"arm64", false, "3.0.0"},
+		{"386", false, ""},
+	} {
+		var v string
+
+		if d := a.Latest("linux", tt.arch, ".aci", tt.preRelease); d != nil {
+			v = d.Version
+		}
+
+		if v != tt.out {
+			t.Errorf("%s %v: wrong latest version: %s", tt.arch, tt.preRelease, v)
+		}
+	}
+}
+
+func TestBuildAciListKeepsFiles(t *testing.T) {
+	d := time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC)
+	images := []struct {
+		file, name, version, digest string
+	}{
+		{"example.com/app-my-stable-linux-amd64.aci", "example.com/app", "my-stable", "sha512-a"},
+		{"example.com/lib-x-1.0.0-linux-amd64.aci", "example.com/lib-x", "1.0.0", "sha512-b"},
+		{"example.com/tool-2.0.0-linux-amd64.aci", "example.com/tool", "2.0.0", "sha512-c"},
+	}
+
+	data := []RawFile{}
+
+	for i, img := range images {
+		date := d.Add(time.Duration(i) * time.Hour)
+		manifest := `{"acKind": "ImageManifest", "name": "` + img.name + `"}`
+
+		data = append(
+			data,
+			RawFile{Name: img.file + SignatureExt, Date: date, Size: 10},
+			RawFile{Name: img.file + ManifestExt, Date: date, Size: 20, Content: []byte(manifest)},
+		)
+
+		// The digests are known from the listing or from the sidecars.
+		if i%2 == 0 {
+			data = append(data, RawFile{Name: img.file, Date: date, Size: int64(100 + i), Digest: img.digest})
+		} else {
+			data = append(
+				data,
+				RawFile{Name: img.file, Date: date, Size: int64(100 + i)},
+				RawFile{Name: img.file + DigestExt, Date: date, Size: 8, Content: []byte(img.digest + "\n")},
+			)
+		}
+	}
+
+	acis, invalid := BuildAciList(data)
+
+	if len(invalid) != 0 || len(acis) != len(images) {
+		t.Fatalf("Wrong listing: %+v %+v", acis, invalid)
+	}
+
+	for i, img := range images {
+		var details *AciDetails
+
+		for _, a := range acis {
+			if a.Name == img.name && len(a.Details) == 1 {
+				details = &a.Details[0]
+			}
+		}
+
+		// The names come from the manifest of each image.
+		if details == nil {
+			t.Errorf("%s: image not found in %+v", img.name, acis)
+			continue
+		}
+
+		e := AciDetails{
+			Version:      img.version,
+			OS:           "linux",
+			Arch:         "amd64",
+			File:         img.file,
+			Signed:       true,
+			Manifest:     true,
+			LastMod:      d.Add(time.Duration(i) * time.Hour).Format(time.RubyDate),
+			LastModified: d.Add(time.Duration(i) * time.Hour),
+			Size:         int64(100 + i),
+			Digest:       img.digest,
+		}
+
+		if !reflect.DeepEqual(*details, e) {
+			t.Errorf("%s: wrong details: %+v", img.name, *details)
+		}
+	}
+}
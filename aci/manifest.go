@@ -0,0 +1,36 @@
+package aci
+
+import "encoding/json"
+
+type Label struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Manifest holds the fields of an appc image manifest acserver relies on.
+type Manifest struct {
+	ACKind    string  `json:"acKind"`
+	ACVersion string  `json:"acVersion"`
+	Name      string  `json:"name"`
+	Labels    []Label `json:"labels,omitempty"`
+}
+
+func ParseManifest(blob []byte) (*Manifest, error) {
+	m := &Manifest{}
+
+	if err := json.Unmarshal(blob, m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (m *Manifest) Label(name string) string {
+	for _, l := range m.Labels {
+		if l.Name == name {
+			return l.Value
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,144 @@
+package aci
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrUnknownExtension = errors.New("unknown extension")
+	ErrMissingTokens    = errors.New("expected {name}-{version}-{os}-{arch}.{ext}")
+	ErrManifestMismatch = errors.New("filename doesn't match the manifest")
+)
+
+// Extensions lists the suffixes an image file can have, the longest ones
+// first so that they are matched before their prefixes.
+var Extensions = []string{".aci.gz", ".aci.bz2", ".aci.xz", ".aci"}
+
+// Filename builds the name of an image file following the appc discovery
+// template.
+func Filename(name, version, os, arch, ext string) string {
+	return name + "-" + version + "-" + os + "-" + arch + ext
+}
+
+type Image struct {
+	Name    string
+	Version string
+	OS      string
+	Arch    string
+	Ext     string
+}
+
+// ParseFilename splits a filename following the appc discovery template
+// {name}-{version}-{os}-{arch}.{ext}. Both the name and the version may
+// contain dashes: the version is assumed to start at the first dash followed
+// by something looking like a version number, or at the last dash otherwise.
+func ParseFilename(filename string) (*Image, error) {
+	return ParseFilenameWithManifest(filename, nil)
+}
+
+// ParseFilenameWithManifest is like ParseFilename but relies on the name and
+// labels of the image manifest, when provided, to split the filename.
+func ParseFilenameWithManifest(filename string, m *Manifest) (*Image, error) {
+	img := &Image{}
+
+	for _, ext := range Extensions {
+		if strings.HasSuffix(filename, ext) {
+			img.Ext = ext
+			break
+		}
+	}
+
+	if img.Ext == "" {
+		return nil, ErrUnknownExtension
+	}
+
+	base := strings.TrimSuffix(filename, img.Ext)
+	dir, file := "", base
+
+	if i := strings.LastIndex(base, "/"); i >= 0 {
+		dir, file = base[:i+1], base[i+1:]
+	}
+
+	tokens := strings.Split(file, "-")
+
+	if len(tokens) < 4 {
+		return nil, ErrMissingTokens
+	}
+
+	img.Arch = tokens[len(tokens)-1]
+	img.OS = tokens[len(tokens)-2]
+	rest := tokens[:len(tokens)-2]
+
+	if img.OS == "" || img.Arch == "" {
+		return nil, ErrMissingTokens
+	}
+
+	if m != nil {
+		return img, img.splitWithManifest(dir, strings.Join(rest, "-"), m)
+	}
+
+	split := len(rest) - 1
+
+	for i := 1; i < len(rest); i++ {
+		if versionLike(strings.Join(rest[i:], "-")) {
+			split = i
+			break
+		}
+	}
+
+	img.Name = dir + strings.Join(rest[:split], "-")
+	img.Version = strings.Join(rest[split:], "-")
+
+	if img.Name == dir || img.Version == "" {
+		return nil, ErrMissingTokens
+	}
+
+	return img, nil
+}
+
+func (img *Image) splitWithManifest(dir, rest string, m *Manifest) error {
+	if os := m.Label("os"); os != "" && os != img.OS {
+		return ErrManifestMismatch
+	}
+
+	if arch := m.Label("arch"); arch != "" && arch != img.Arch {
+		return ErrManifestMismatch
+	}
+
+	full := dir + rest
+
+	if m.Name != "" && strings.HasPrefix(full, m.Name+"-") {
+		img.Name = m.Name
+		img.Version = strings.TrimPrefix(full, m.Name+"-")
+	} else if v := m.Label("version"); v != "" && strings.HasSuffix(full, "-"+v) {
+		img.Name = strings.TrimSuffix(full, "-"+v)
+		img.Version = v
+	} else {
+		return ErrManifestMismatch
+	}
+
+	if img.Name == "" || img.Version == "" {
+		return ErrMissingTokens
+	}
+
+	return nil
+}
+
+// versionLike reports whether s starts like a version number: an optional
+// "v" followed by digits, the whole being followed by nothing, a dot, a dash
+// or a plus sign.
+func versionLike(s string) bool {
+	s = strings.TrimPrefix(s, "v")
+	i := 0
+
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+
+	if i == 0 {
+		return false
+	}
+
+	return i == len(s) || strings.IndexByte(".-+", s[i]) >= 0
+}
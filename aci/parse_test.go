@@ -0,0 +1,113 @@
+package aci
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFilename(t *testing.T) {
+	for _, tt := range []struct {
+		in  string
+		out *Image
+		err error
+	}{
+		{
+			"foo.com/bar-latest-linux-amd64.aci",
+			&Image{"foo.com/bar", "latest", "linux", "amd64", ".aci"},
+			nil,
+		},
+		{
+			"example.com/my-app-1.0.0-linux-amd64.aci",
+			&Image{"example.com/my-app", "1.0.0", "linux", "amd64", ".aci"},
+			nil,
+		},
+		{
+			"example.com/my-app-1.0.0-rc1-linux-amd64.aci",
+			&Image{"example.com/my-app", "1.0.0-rc1", "linux", "amd64", ".aci"},
+			nil,
+		},
+		{
+			"example.com/team/etcd-v2.2.0+git-linux-arm64.aci.gz",
+			&Image{"example.com/team/etcd", "v2.2.0+git", "linux", "arm64", ".aci.gz"},
+			nil,
+		},
+		{
+			"example.com/my-app-stable-darwin-amd64.aci",
+			&Image{"example.com/my-app", "stable", "darwin", "amd64", ".aci"},
+			nil,
+		},
+		{
+			"example.com/app-2fa-3-linux-amd64.aci",
+			&Image{"example.com/app-2fa", "3", "linux", "amd64", ".aci"},
+			nil,
+		},
+		{"foo.com/fuz-wrong", nil, ErrUnknownExtension},
+		{"foo.com/bar-linux-amd64.aci", nil, ErrMissingTokens},
+		{"foo.com/bar-1.0.0-linux-.aci", nil, ErrMissingTokens},
+		{"foo.com/-1.0.0-linux-amd64.aci", nil, ErrMissingTokens},
+	} {
+		img, err := ParseFilename(tt.in)
+
+		if err != tt.err {
+			t.Errorf("%s: wrong error: %v", tt.in, err)
+		}
+
+		if !reflect.DeepEqual(img, tt.out) {
+			t.Errorf("%s: wrong parsing: %+v", tt.in, img)
+		}
+	}
+}
+
+func TestParseFilenameWithManifest(t *testing.T) {
+	manifest := func(name string, labels ...Label) *Manifest {
+		return &Manifest{ACKind: "ImageManifest", Name: name, Labels: labels}
+	}
+
+	for _, tt := range []struct {
+		in       string
+		manifest *Manifest
+		out      *Image
+		err      error
+	}{
+		{
+			"example.com/my-app-2-beta-linux-amd64.aci",
+			manifest("example.com/my-app-2"),
+			&Image{"example.com/my-app-2", "beta", "linux", "amd64", ".aci"},
+			nil,
+		},
+		{
+			"example.com/app-1-2-linux-amd64.aci",
+			manifest("", Label{"version", "1-2"}),
+			&Image{"example.com/app", "1-2", "linux", "amd64", ".aci"},
+			nil,
+		},
+		{
+			"example.com/app-latest-linux-amd64.aci",
+			manifest("example.com/app", Label{"version", "1.2.0"}),
+			&Image{"example.com/app", "latest", "linux", "amd64", ".aci"},
+			nil,
+		},
+		{
+			"example.com/app-1.0.0-linux-amd64.aci",
+			manifest("example.com/other"),
+			nil,
+			ErrManifestMismatch,
+		},
+		{
+			"example.com/app-1.0.0-linux-amd64.aci",
+			manifest("example.com/app", Label{"arch", "arm64"}),
+			nil,
+			ErrManifestMismatch,
+		},
+	} {
+		img, err := ParseFilenameWithManifest(tt.in, tt.manifest)
+
+		if err != tt.err {
+			t.Errorf("%s: wrong error: %v", tt.in, err)
+		}
+
+		if err == nil && !reflect.DeepEqual(img, tt.out) {
+			t.Errorf("%s: wrong parsing: %+v", tt.in, img)
+		}
+	}
+}
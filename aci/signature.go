@@ -0,0 +1,213 @@
+package aci
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	signaturePacketTag = 2
+
+	issuerSubpacket            = 16
+	issuerFingerprintSubpacket = 33
+)
+
+var ErrInvalidSignature = errors.New("invalid OpenPGP signature")
+
+// SignatureKeyID returns the ID of the key an OpenPGP signature, armored or
+// not, was issued by as 16 uppercase hexadecimal digits.
+func SignatureKeyID(sig []byte) (string, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(sig), []byte("-----BEGIN PGP")) {
+		var err error
+
+		if sig, err = dearmor(sig); err != nil {
+			return "", err
+		}
+	}
+
+	for len(sig) > 0 {
+		tag, body, rest, err := readPacket(sig)
+
+		if err != nil {
+			return "", err
+		}
+
+		if tag == signaturePacketTag {
+			id, err := issuer(body)
+
+			if err != nil {
+				return "", err
+			}
+
+			return fmt.Sprintf("%016X", id), nil
+		}
+
+		sig = rest
+	}
+
+	return "", ErrInvalidSignature
+}
+
+func dearmor(blob []byte) ([]byte, error) {
+	var (
+		s       = bufio.NewScanner(bytes.NewReader(blob))
+		buf     = &bytes.Buffer{}
+		inBody  bool
+		started bool
+	)
+
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+
+		switch {
+		case strings.HasPrefix(line, "-----BEGIN PGP"):
+			started = true
+		case !started:
+		case strings.HasPrefix(line, "-----END PGP"):
+			return base64.StdEncoding.DecodeString(buf.String())
+		case !inBody:
+			inBody = line == ""
+		case strings.HasPrefix(line, "="):
+			// Checksum line, the packets are parsed strictly enough anyway.
+		default:
+			buf.WriteString(line)
+		}
+	}
+
+	return nil, ErrInvalidSignature
+}
+
+func readPacket(blob []byte) (tag byte, body, rest []byte, err error) {
+	if len(blob) < 2 || blob[0]&0x80 == 0 {
+		return 0, nil, nil, ErrInvalidSignature
+	}
+
+	var length, offset int
+
+	if blob[0]&0x40 != 0 {
+		tag = blob[0] & 0x3f
+
+		switch o := blob[1]; {
+		case o < 192:
+			length, offset = int(o), 2
+		case o < 224 && len(blob) >= 3:
+			length, offset = (int(o)-192)<<8+int(blob[2])+192, 3
+		case o == 255 && len(blob) >= 6:
+			length, offset = int(binary.BigEndian.Uint32(blob[2:6])), 6
+		default:
+			return 0, nil, nil, ErrInvalidSignature
+		}
+	} else {
+		tag = (blob[0] >> 2) & 0x0f
+
+		switch blob[0] & 0x03 {
+		case 0:
+			length, offset = int(blob[1]), 2
+		case 1:
+			if len(blob) < 3 {
+				return 0, nil, nil, ErrInvalidSignature
+			}
+
+			length, offset = int(binary.BigEndian.Uint16(blob[1:3])), 3
+		case 2:
+			if len(blob) < 5 {
+				return 0, nil, nil, ErrInvalidSignature
+			}
+
+			length, offset = int(binary.BigEndian.Uint32(blob[1:5])), 5
+		default:
+			length, offset = len(blob)-1, 1
+		}
+	}
+
+	if length < 0 || offset+length > len(blob) {
+		return 0, nil, nil, ErrInvalidSignature
+	}
+
+	return tag, blob[offset : offset+length], blob[offset+length:], nil
+}
+
+func issuer(body []byte) (uint64, error) {
+	if len(body) == 0 {
+		return 0, ErrInvalidSignature
+	}
+
+	switch body[0] {
+	case 3:
+		if len(body) < 15 {
+			return 0, ErrInvalidSignature
+		}
+
+		return binary.BigEndian.Uint64(body[7:15]), nil
+	case 4:
+		if len(body) < 6 {
+			return 0, ErrInvalidSignature
+		}
+
+		rest := body[4:]
+
+		// Both the hashed and unhashed subpacket areas may hold the issuer.
+		for i := 0; i < 2; i++ {
+			if len(rest) < 2 {
+				return 0, ErrInvalidSignature
+			}
+
+			n := int(binary.BigEndian.Uint16(rest[:2]))
+
+			if len(rest) < 2+n {
+				return 0, ErrInvalidSignature
+			}
+
+			if id, ok := subpacketIssuer(rest[2 : 2+n]); ok {
+				return id, nil
+			}
+
+			rest = rest[2+n:]
+		}
+	}
+
+	return 0, ErrInvalidSignature
+}
+
+func subpacketIssuer(area []byte) (uint64, bool) {
+	for len(area) > 0 {
+		var length, offset int
+
+		switch o := area[0]; {
+		case o < 192:
+			length, offset = int(o), 1
+		case o < 255 && len(area) >= 2:
+			length, offset = (int(o)-192)<<8+int(area[1])+192, 2
+		case o == 255 && len(area) >= 5:
+			length, offset = int(binary.BigEndian.Uint32(area[1:5])), 5
+		default:
+			return 0, false
+		}
+
+		if length < 1 || offset+length > len(area) {
+			return 0, false
+		}
+
+		data := area[offset+1 : offset+length]
+
+		switch area[offset] & 0x7f {
+		case issuerSubpacket:
+			if len(data) == 8 {
+				return binary.BigEndian.Uint64(data), true
+			}
+		case issuerFingerprintSubpacket:
+			if len(data) >= 9 {
+				return binary.BigEndian.Uint64(data[len(data)-8:]), true
+			}
+		}
+
+		area = area[offset+length:]
+	}
+
+	return 0, false
+}
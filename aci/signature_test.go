@@ -0,0 +1,45 @@
+package aci
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+const armoredSignature = `-----BEGIN PGP SIGNATURE-----
+
+iHUEABYIAB0WIQRR1BUDI/OiV2vYu9pzmOsnsszOTAUCatV7JQAKCRBzmOsnsszO
+TPjeAQC+a0lcm3CTe2JQR7YnH0aT8LawAPZv8z2GElN/8lEgAgEA6JtkJf6+4LHf
+EUli+co6a+dWurCWEjhfRAJyBV8kJAU=
+=eJvp
+-----END PGP SIGNATURE-----
+`
+
+func TestSignatureKeyID(t *testing.T) {
+	binary, _ := base64.StdEncoding.DecodeString(
+		"iHUEABYIAB0WIQRR1BUDI/OiV2vYu9pzmOsnsszOTAUCatV7JQAKCRBzmOsnsszO" +
+			"TPjeAQC+a0lcm3CTe2JQR7YnH0aT8LawAPZv8z2GElN/8lEgAgEA6JtkJf6+4LHf" +
+			"EUli+co6a+dWurCWEjhfRAJyBV8kJAU=",
+	)
+
+	for _, tt := range []struct {
+		name string
+		in   []byte
+		out  string
+		err  error
+	}{
+		{"armored", []byte(armoredSignature), "7398EB27B2CCCE4C", nil},
+		{"binary", binary, "7398EB27B2CCCE4C", nil},
+		{"truncated", binary[:20], "", ErrInvalidSignature},
+		{"garbage", []byte("not a signature"), "", ErrInvalidSignature},
+	} {
+		id, err := SignatureKeyID(tt.in)
+
+		if err != tt.err {
+			t.Errorf("%s: wrong error: %v", tt.name, err)
+		}
+
+		if id != tt.out {
+			t.Errorf("%s: wrong key ID: %s", tt.name, id)
+		}
+	}
+}
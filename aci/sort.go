@@ -0,0 +1,120 @@
+package aci
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/appc/acserver/semver"
+)
+
+// LatestVersion is the version clients fetch when they don't ask for one.
+const LatestVersion = "latest"
+
+// SortAcis orders the images by name, and their details by version: semantic
+// versions first by precedence, then the other ones alphabetically. Ties are
+// broken by upload time, then by platform.
+func SortAcis(acis []Aci) {
+	sort.Sort(byName(acis))
+
+	for _, a := range acis {
+		sort.Sort(byVersion(a.Details))
+	}
+}
+
+// CompareVersions returns -1, 0 or 1 if a sorts before, with or after b.
+func CompareVersions(a, b string) int {
+	va, errA := semver.Parse(a)
+	vb, errB := semver.Parse(b)
+
+	switch {
+	case errA == nil && errB == nil:
+		return va.Compare(vb)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+
+	return 0
+}
+
+// Latest returns the details of the highest semantic version released for a
+// platform and extension, ignoring yanked versions and, unless asked,
+// pre-releases. It returns nil if no version qualifies.
+func (a *Aci) Latest(os, arch, ext string, preRelease bool) *AciDetails {
+	var (
+		res     *AciDetails
+		version *semver.Version
+	)
+
+	for i, d := range a.Details {
+		if d.Yanked || d.OS != os || d.Arch != arch || FileExt(d.File) != ext {
+			continue
+		}
+
+		v, err := semver.Parse(d.Version)
+
+		if err != nil || (v.IsPreRelease() && !preRelease) {
+			continue
+		}
+
+		if res == nil {
+			res, version = &a.Details[i], v
+			continue
+		}
+
+		c := v.Compare(version)
+
+		if c > 0 || c == 0 && d.LastModified.After(res.LastModified) {
+			res, version = &a.Details[i], v
+		}
+	}
+
+	return res
+}
+
+// FileExt returns the image extension of a file, or an empty string if it has
+// none of the known ones.
+func FileExt(file string) string {
+	for _, ext := range Extensions {
+		if strings.HasSuffix(file, ext) {
+			return ext
+		}
+	}
+
+	return ""
+}
+
+type byName []Aci
+
+func (s byName) Len() int           { return len(s) }
+func (s byName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+func (s byName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+type byVersion []AciDetails
+
+func (s byVersion) Len() int      { return len(s) }
+func (s byVersion) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byVersion) Less(i, j int) bool {
+	if c := CompareVersions(s[i].Version, s[j].Version); c != 0 {
+		return c < 0
+	}
+
+	if !s[i].LastModified.Equal(s[j].LastModified) {
+		return s[i].LastModified.Before(s[j].LastModified)
+	}
+
+	if s[i].OS != s[j].OS {
+		return s[i].OS < s[j].OS
+	}
+
+	if s[i].Arch != s[j].Arch {
+		return s[i].Arch < s[j].Arch
+	}
+
+	return s[i].File < s[j].File
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/appc/acserver/audit"
+	"github.com/appc/acserver/storage"
+
+	"github.com/appc/acserver/Godeps/_workspace/src/github.com/gorilla/context"
+)
+
+// auditDetails is filled by the audited handlers with what they learn about
+// the operation.
+type auditDetails struct {
+	action   string
+	uploader string
+	image    string
+	version  string
+	tag      string
+	uploadID uint64
+	digest   string
+	err      string
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// audited records the outcome of a mutating handler in the audit log.
+func (m *Mux) audited(action string, handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if m.audit == nil {
+			handler(w, req)
+			return
+		}
+
+		d := &auditDetails{action: action}
+		context.Set(req, auditKey, d)
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler(rec, req)
+
+		r := &audit.Record{
+			Time:      time.Now(),
+			Action:    d.action,
+			Principal: principal(req),
+			Uploader:  d.uploader,
+			Client:    clientAddr(req),
+			Image:     d.image,
+			Version:   d.version,
+			Tag:       d.tag,
+			UploadID:  d.uploadID,
+			Digest:    d.digest,
+			Outcome:   audit.Success,
+			Status:    rec.status,
+			Error:     d.err,
+		}
+
+		// The upload routes don't require credentials, the callers
+		// sending some are still credited.
+		if r.Principal == "" {
+			r.Principal = m.identify(req)
+		}
+
+		if rec.status >= 400 || d.err != "" {
+			r.Outcome = audit.Failure
+		}
+
+		if err := m.audit.Write(r); err != nil {
+			log.Printf("audit: %s on %s not recorded: %v", r.Action, r.Image, err)
+		}
+	}
+}
+
+// auditing returns the details of the audited request, discarded if it
+// isn't.
+func auditing(req *http.Request) *auditDetails {
+	if d, ok := context.Get(req, auditKey).(*auditDetails); ok {
+		return d
+	}
+
+	return &auditDetails{}
+}
+
+func clientAddr(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+
+	if err != nil {
+		return req.RemoteAddr
+	}
+
+	return host
+}
+
+func (m *Mux) queryAudit(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if m.audit == nil {
+		writeError(w, storage.ErrNotSupported)
+		return
+	}
+
+	q := req.URL.Query()
+	limit, err := intParam(q.Get("limit"), 0)
+
+	if err != nil || limit < 0 {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid limit")
+		return
+	}
+
+	records, err := m.audit.Query(
+		audit.Filter{
+			Image:     q.Get("image"),
+			Principal: q.Get("principal"),
+			Limit:     limit,
+		},
+	)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, records)
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/appc/acserver/audit"
+)
+
+type recordSink struct {
+	records []*audit.Record
+}
+
+func (s *recordSink) Write(r *audit.Record) error {
+	s.records = append(s.records, r)
+	return nil
+}
+
+func (s *recordSink) Query(audit.Filter) ([]*audit.Record, error) {
+	return s.records, nil
+}
+
+func TestAuditCreditsCaller(t *testing.T) {
+	sink := &recordSink{}
+	m, _, cleanup := newTestMux(t, Config{Audit: sink})
+	defer cleanup()
+
+	for _, r := range []struct{ principal, method, url, body string }{
+		{"bob", "POST", "/example.com/app-1.0.0-linux-amd64.aci/startupload", ""},
+		{"", "POST", "/complete/1", `{"success": false, "reason": "interrupted"}`},
+		{"bob", "POST", "/example.com/app-1.0.0-linux-amd64.aci/startupload", ""},
+		{"alice", "POST", "/complete/2", `{"success": false, "reason": "interrupted"}`},
+	} {
+		do(m, r.principal, r.method, r.url, r.body)
+	}
+
+	e := []audit.Record{
+		{Action: "startupload", Principal: "bob", Uploader: "bob"},
+		{Action: "cancel", Uploader: "bob"},
+		{Action: "startupload", Principal: "bob", Uploader: "bob"},
+		{Action: "cancel", Principal: "alice", Uploader: "bob"},
+	}
+
+	if len(sink.records) != len(e) {
+		t.Fatalf("Wrong number of records: %d", len(sink.records))
+	}
+
+	for i, r := range sink.records {
+		if r.Action != e[i].Action || r.Principal != e[i].Principal || r.Uploader != e[i].Uploader {
+			t.Errorf("Wrong record %d: %+v", i, r)
+		}
+	}
+}
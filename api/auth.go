@@ -0,0 +1,90 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/appc/acserver/auth"
+	"github.com/appc/acserver/upload"
+
+	"github.com/appc/acserver/Godeps/_workspace/src/github.com/gorilla/context"
+)
+
+type contextKey int
+
+const (
+	principalKey contextKey = iota
+	auditKey
+)
+
+func (m *Mux) authenticated(handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if m.authenticator == nil {
+			writeError(w, auth.ErrForbidden)
+			return
+		}
+
+		principal, err := m.authenticator.Authenticate(req)
+
+		if err != nil {
+			if err == auth.ErrUnauthorized {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="acserver"`)
+			}
+
+			writeError(w, err)
+			return
+		}
+
+		context.Set(req, principalKey, principal)
+		handler(w, req)
+	}
+}
+
+// admin restricts a handler to the administrators, none are allowed if none
+// are configured.
+func (m *Mux) admin(handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return m.authenticated(func(w http.ResponseWriter, req *http.Request) {
+		if !m.isAdmin(principal(req)) {
+			writeError(w, auth.ErrForbidden)
+			return
+		}
+
+		handler(w, req)
+	})
+}
+
+// isAdmin reports whether an authenticated principal is an administrator.
+func (m *Mux) isAdmin(principal string) bool {
+	return m.admins[principal]
+}
+
+// owns reports whether the principal of an authenticated handler started an
+// upload or is an administrator. Only the administrators own the anonymous
+// uploads.
+func (m *Mux) owns(req *http.Request, up *upload.Upload) bool {
+	p := principal(req)
+
+	return (up.Uploader != "" && up.Uploader == p) || m.isAdmin(p)
+}
+
+// principal returns the principal an authenticated handler is called for.
+func principal(req *http.Request) string {
+	p, _ := context.Get(req, principalKey).(string)
+
+	return p
+}
+
+// identify returns the principal an optionally authenticated request comes
+// from, or an empty string for anonymous clients.
+func (m *Mux) identify(req *http.Request) string {
+	if m.authenticator == nil || req.Header.Get("Authorization") == "" {
+		return ""
+	}
+
+	principal, err := m.authenticator.Authenticate(req)
+
+	if err != nil {
+		return ""
+	}
+
+	return principal
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/appc/acserver/aci"
+	"github.com/appc/acserver/storage"
+
+	"github.com/appc/acserver/Godeps/_workspace/src/github.com/gorilla/mux"
+)
+
+type digestDetails struct {
+	Digest string              `json:"digest"`
+	Images []storage.Reference `json:"images"`
+}
+
+// downloadByID serves the ACI of an image ID, straight from its blob in the
+// content addressed layout, or the signature or manifest published with it.
+func (m *Mux) downloadByID(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	digest, ext := mux.Vars(req)["id"], ""
+
+	for _, e := range []string{aci.SignatureExt, aci.ManifestExt} {
+		if strings.HasSuffix(digest, e) {
+			digest, ext = strings.TrimSuffix(digest, e), e
+		}
+	}
+
+	if ext == "" && storage.ValidDigest(digest) && m.serveBlob(w, req, digest) {
+		return
+	}
+
+	refs, err := storage.FindDigest(m.store, digest)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	ref := pickReference(refs, ext)
+
+	if ref == nil {
+		writeError(w, storage.ErrNotFound)
+		return
+	}
+
+	m.serveFile(w, req, ref.File+ext)
+}
+
+// serveBlob answers with the blob of a digest, it reports false without
+// answering if the storage isn't content addressed or doesn't have it.
+func (m *Mux) serveBlob(w http.ResponseWriter, req *http.Request, digest string) bool {
+	b, ok := m.store.(storage.BlobStore)
+
+	if !ok {
+		return false
+	}
+
+	rs, err := b.DownloadBlob(digest)
+
+	switch err {
+	case nil:
+	case storage.ErrNotSupported, storage.ErrNotFound:
+		return false
+	default:
+		writeError(w, err)
+		return true
+	}
+
+	defer rs.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("ETag", strconv.Quote(digest))
+	http.ServeContent(w, req, digest, time.Time{}, rs)
+
+	return true
+}
+
+// pickReference prefers the files not yanked among the ones holding an ACI
+// and, for a signature or a manifest, published with it.
+func pickReference(refs []storage.Reference, ext string) *storage.Reference {
+	var res *storage.Reference
+
+	for i, r := range refs {
+		if ext == aci.SignatureExt && !r.Signed || ext == aci.ManifestExt && !r.Manifest {
+			continue
+		}
+
+		if !r.Yanked {
+			return &refs[i]
+		}
+
+		if res == nil {
+			res = &refs[i]
+		}
+	}
+
+	return res
+}
+
+func (m *Mux) lookupID(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	digest := mux.Vars(req)["id"]
+	refs, err := storage.FindDigest(m.store, digest)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, digestDetails{Digest: digest, Images: refs})
+}
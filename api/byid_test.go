@@ -0,0 +1,99 @@
+package api
+
+import (
+	"crypto/sha512"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/appc/acserver/storage"
+	"github.com/appc/acserver/storage/filesystem"
+	"github.com/appc/acserver/upload"
+)
+
+func TestDownloadByID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acserver-api")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	s, err := filesystem.NewContentAddressedStorage(dir, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, _, cleanup := newTestMux(t, Config{Store: s})
+	defer cleanup()
+
+	id := send(t, m, "bob", "example.com/app", "1.0.0", 3)
+
+	if w := do(m, "bob", "POST", "/complete/"+id, `{"success": true}`); w.Code != http.StatusOK {
+		t.Fatalf("Upload failed: %s", w.Body.String())
+	}
+
+	sum := sha512.Sum512([]byte("aaa"))
+	digest := storage.FormatDigest(sum[:])
+
+	for _, tt := range []struct {
+		url, body string
+		code      int
+	}{
+		{"/by-id/" + digest, "aaa", http.StatusOK},
+		{"/by-id/" + digest + ".asc", "signature", http.StatusOK},
+		{"/by-id/sha512-" + strings.Repeat("ef", 64), "", http.StatusNotFound},
+	} {
+		w := do(m, "", "GET", tt.url, "")
+
+		if w.Code != tt.code || (tt.body != "" && w.Body.String() != tt.body) {
+			t.Errorf("%s: got %d %q", tt.url, w.Code, w.Body.String())
+		}
+	}
+
+	// The ACIs are served from their blob, without looking for names.
+	if err := os.Remove(dir + "/example.com/app-1.0.0-linux-amd64.aci"); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := do(m, "", "GET", "/by-id/"+digest, ""); w.Code != http.StatusOK || w.Body.String() != "aaa" {
+		t.Errorf("Blob not served: %d %q", w.Code, w.Body.String())
+	}
+
+	// Without blobs, the ACIs are found from the digests of the listings,
+	// the manifests only if published with them.
+	m, fs, cleanup := newTestMux(t, Config{})
+	defer cleanup()
+
+	up := upload.Upload{ID: 1, Image: "example.com/app-1.0.0-linux-amd64.aci", Digest: digest}
+
+	for _, err := range []error{
+		fs.UploadACI(up, strings.NewReader("aaa")),
+		fs.UploadASC(up, strings.NewReader("signature")),
+		fs.FinishUpload(up),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		url, body string
+		code      int
+	}{
+		{"/by-id/" + digest, "aaa", http.StatusOK},
+		{"/by-id/" + digest + ".asc", "signature", http.StatusOK},
+		{"/by-id/" + digest + ".manifest", "", http.StatusNotFound},
+		{"/api/v1/by-id/" + digest, "", http.StatusOK},
+	} {
+		w := do(m, "", "GET", tt.url, "")
+
+		if w.Code != tt.code || (tt.body != "" && w.Body.String() != tt.body) {
+			t.Errorf("%s: got %d %q", tt.url, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/appc/acserver/aci"
+	"github.com/appc/acserver/events"
+	"github.com/appc/acserver/storage"
+
+	"github.com/appc/acserver/Godeps/_workspace/src/github.com/gorilla/mux"
+)
+
+const (
+	defaultPerPage = 50
+	maxPerPage     = 500
+)
+
+type imageList struct {
+	Images  []aci.Aci         `json:"images"`
+	Total   int               `json:"total"`
+	Page    int               `json:"page"`
+	PerPage int               `json:"per_page"`
+	Invalid []aci.InvalidFile `json:"invalid"`
+}
+
+type versionDetails struct {
+	Name      string           `json:"name"`
+	Version   string           `json:"version"`
+	Platforms []aci.AciDetails `json:"platforms"`
+}
+
+func (m *Mux) listImages(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	q := req.URL.Query()
+
+	page, err := intParam(q.Get("page"), 1)
+
+	if err != nil || page < 1 {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid page")
+		return
+	}
+
+	perPage, err := intParam(q.Get("per_page"), defaultPerPage)
+
+	if err != nil || perPage < 1 || perPage > maxPerPage {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid per_page")
+		return
+	}
+
+	acis, invalid, err := m.store.ListACIs()
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	acis = visibleAcis(acis, q.Get("yanked") == "true")
+	prefix := q.Get("prefix")
+	res := []aci.Aci{}
+
+	for _, a := range acis {
+		if strings.HasPrefix(a.Name, prefix) {
+			res = append(res, a)
+		}
+	}
+
+	invalidRes := []aci.InvalidFile{}
+
+	for _, f := range invalid {
+		if strings.HasPrefix(f.Name, prefix) {
+			invalidRes = append(invalidRes, f)
+		}
+	}
+
+	aci.SortAcis(res)
+
+	start := (page - 1) * perPage
+	end := start + perPage
+
+	if start > len(res) {
+		start = len(res)
+	}
+
+	if end > len(res) {
+		end = len(res)
+	}
+
+	writeJSON(
+		w,
+		http.StatusOK,
+		imageList{
+			Images:  res[start:end],
+			Total:   len(res),
+			Page:    page,
+			PerPage: perPage,
+			Invalid: invalidRes,
+		},
+	)
+}
+
+func (m *Mux) getImage(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	a, err := m.findImage(
+		mux.Vars(req)["name"],
+		req.URL.Query().Get("yanked") == "true",
+	)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, a)
+}
+
+func (m *Mux) imageVersion(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case "GET":
+		m.getImageVersion(w, req)
+	case "DELETE":
+		m.audited("delete", m.authenticated(m.deleteImage))(w, req)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (m *Mux) getImageVersion(w http.ResponseWriter, req *http.Request) {
+	var (
+		platforms []aci.AciDetails
+		err       error
+		vars      = mux.Vars(req)
+	)
+
+	if m.resolveLatest && vars["version"] == aci.LatestVersion {
+		platforms, err = m.latestPlatforms(req)
+	}
+
+	if err == nil && len(platforms) == 0 {
+		platforms, err = m.findPlatforms(req, true)
+	}
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	for i, d := range platforms {
+		info, err := m.store.StatACI(d.File)
+
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		platforms[i].Digest = info.Digest
+	}
+
+	writeJSON(
+		w,
+		http.StatusOK,
+		versionDetails{
+			Name:      vars["name"],
+			Version:   vars["version"],
+			Platforms: platforms,
+		},
+	)
+}
+
+func (m *Mux) deleteImage(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	a := auditing(req)
+	a.image, a.version = vars["name"], vars["version"]
+
+	platforms, err := m.findPlatforms(req, true)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	for _, d := range platforms {
+		if err := m.store.DeleteACI(d.File); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		e := events.New(events.ImageDeleted, d.File)
+		e.Principal = principal(req)
+		m.publish(e)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (m *Mux) yankImage(w http.ResponseWriter, req *http.Request) {
+	var yanked bool
+
+	switch req.Method {
+	case "PUT":
+		yanked = true
+	case "DELETE":
+		yanked = false
+		auditing(req).action = "unyank"
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	vars := mux.Vars(req)
+	d := auditing(req)
+	d.image, d.version = vars["name"], vars["version"]
+
+	platforms, err := m.findPlatforms(req, true)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	for _, d := range platforms {
+		if err := m.store.YankACI(d.File, yanked); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (m *Mux) findPlatforms(req *http.Request, includeYanked bool) ([]aci.AciDetails, error) {
+	vars := mux.Vars(req)
+	a, err := m.findImage(vars["name"], includeYanked)
+
+	if err != nil {
+		return nil, err
+	}
+
+	res := []aci.AciDetails{}
+
+	for _, d := range a.Details {
+		if d.Version != vars["version"] {
+			continue
+		}
+
+		if os, ok := vars["os"]; ok && d.OS != os {
+			continue
+		}
+
+		if arch, ok := vars["arch"]; ok && d.Arch != arch {
+			continue
+		}
+
+		res = append(res, d)
+	}
+
+	if len(res) == 0 {
+		return nil, storage.ErrNotFound
+	}
+
+	return res, nil
+}
+
+func (m *Mux) findImage(name string, includeYanked bool) (*aci.Aci, error) {
+	acis, _, err := m.store.ListACIs()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range visibleAcis(acis, includeYanked) {
+		if a.Name == name {
+			aci.SortAcis([]aci.Aci{a})
+			return &a, nil
+		}
+	}
+
+	return nil, storage.ErrNotFound
+}
+
+func visibleAcis(acis []aci.Aci, includeYanked bool) []aci.Aci {
+	if includeYanked {
+		return acis
+	}
+
+	res := []aci.Aci{}
+
+	for _, a := range acis {
+		details := []aci.AciDetails{}
+
+		for _, d := range a.Details {
+			if !d.Yanked {
+				details = append(details, d)
+			}
+		}
+
+		if len(details) > 0 {
+			res = append(res, aci.Aci{Name: a.Name, Details: details})
+		}
+	}
+
+	return res
+}
+
+func intParam(v string, def int) (int, error) {
+	if v == "" {
+		return def, nil
+	}
+
+	return strconv.Atoi(v)
+}
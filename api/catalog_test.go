@@ -0,0 +1,48 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDeleteAndYankRequireCredentials(t *testing.T) {
+	m, _, cleanup := newTestMux(t, Config{})
+	defer cleanup()
+
+	id := send(t, m, "bob", "example.com/app", "1.0.0", 3)
+
+	if w := do(m, "bob", "POST", "/complete/"+id, `{"success": true}`); w.Code != http.StatusOK {
+		t.Fatalf("Upload failed: %s", w.Body.String())
+	}
+
+	version := "/api/v1/images/example.com/app/versions/1.0.0"
+
+	for _, tt := range []struct {
+		principal, method, url string
+		code                   int
+	}{
+		{"", "PUT", version + "/yank", http.StatusUnauthorized},
+		{"mallory", "PUT", version + "/yank", http.StatusUnauthorized},
+		{"bob", "PUT", version + "/yank", http.StatusNoContent},
+		{"", "DELETE", version + "/yank", http.StatusUnauthorized},
+		{"bob", "DELETE", version + "/yank", http.StatusNoContent},
+		{"", "DELETE", version, http.StatusUnauthorized},
+		{"mallory", "DELETE", version, http.StatusUnauthorized},
+		{"bob", "DELETE", version, http.StatusNoContent},
+		{"bob", "DELETE", version, http.StatusNotFound},
+	} {
+		w := do(m, tt.principal, tt.method, tt.url, "")
+
+		if w.Code != tt.code {
+			t.Errorf("%s %s as %q: got %d, expected %d", tt.method, tt.url, tt.principal, w.Code, tt.code)
+		}
+
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s %s as %q: no challenge", tt.method, tt.url, tt.principal)
+		}
+	}
+
+	if w := do(m, "", "GET", "/example.com/app-1.0.0-linux-amd64.aci", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Deleted image still served: %d", w.Code)
+	}
+}
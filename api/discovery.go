@@ -0,0 +1,78 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"github.com/appc/acserver/storage"
+
+	"github.com/appc/acserver/Godeps/_workspace/src/github.com/gorilla/mux"
+)
+
+// discover answers the meta discovery requests made for a single image,
+// https://{server}/{name}?ac-discovery=1.
+func (m *Mux) discover(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	name := strings.TrimSuffix(mux.Vars(req)["name"], "/")
+	fullName := m.serverName + "/" + name
+
+	if !m.servesPrefix(fullName) {
+		writeError(w, storage.ErrNotFound)
+		return
+	}
+
+	// Yanked images are still fetchable by their exact name.
+	if _, err := m.findImage(name, true); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	t, err := m.discovery.get()
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	buf := &bytes.Buffer{}
+
+	if err := t.Execute(buf, struct {
+		Name       string
+		ServerName string
+		HTTPS      bool
+	}{
+		Name:       fullName,
+		ServerName: m.serverName,
+		HTTPS:      m.https,
+	}); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	buf.WriteTo(w)
+}
+
+// servesPrefix tells whether a full image name falls under the prefixes this
+// instance is configured to answer discovery requests for. Prefixes match
+// whole path segments only.
+func (m *Mux) servesPrefix(name string) bool {
+	if len(m.discoveryPrefixes) == 0 {
+		return true
+	}
+
+	for _, p := range m.discoveryPrefixes {
+		p = strings.TrimSuffix(p, "/")
+
+		if name == p || strings.HasPrefix(name, p+"/") {
+			return true
+		}
+	}
+
+	return false
+}
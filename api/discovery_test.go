@@ -0,0 +1,46 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDiscoveryPrefixes(t *testing.T) {
+	m, _, cleanup := newTestMux(t, Config{
+		TemplateDir:       "../templates",
+		DiscoveryPrefixes: []string{"example.com/app", "example.com/lib/"},
+	})
+	defer cleanup()
+
+	for _, name := range []string{"app", "apple", "lib/core"} {
+		id := send(t, m, "bob", name, "1.0.0", 3)
+
+		if w := do(m, "bob", "POST", "/complete/"+id, `{"success": true}`); w.Code != http.StatusOK {
+			t.Fatalf("%s: upload failed: %s", name, w.Body.String())
+		}
+	}
+
+	for _, tt := range []struct {
+		name string
+		code int
+	}{
+		{"app", http.StatusOK},
+		{"lib/core", http.StatusOK},
+		// Prefixes match whole segments.
+		{"apple", http.StatusNotFound},
+		{"lib/missing", http.StatusNotFound},
+		{"other", http.StatusNotFound},
+	} {
+		w := do(m, "", "GET", "/"+tt.name+"?ac-discovery=1", "")
+
+		if w.Code != tt.code {
+			t.Errorf("%s: got %d, expected %d", tt.name, w.Code, tt.code)
+			continue
+		}
+
+		if tt.code == http.StatusOK && !strings.Contains(w.Body.String(), "example.com/"+tt.name) {
+			t.Errorf("%s: wrong meta tags: %s", tt.name, w.Body.String())
+		}
+	}
+}
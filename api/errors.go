@@ -0,0 +1,61 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/appc/acserver/audit"
+	"github.com/appc/acserver/auth"
+	"github.com/appc/acserver/quota"
+	"github.com/appc/acserver/storage"
+	"github.com/appc/acserver/upload"
+)
+
+type errorMsg struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+func errorStatus(err error) int {
+	switch err {
+	case storage.ErrNotFound, storage.ErrGPGPubKeyNotProvided, upload.ErrNotFound:
+		return http.StatusNotFound
+	case storage.ErrConflict, upload.ErrConflict, upload.ErrInvalidTransition:
+		return http.StatusConflict
+	case storage.ErrInvalidName, upload.ErrEmptyName:
+		return http.StatusBadRequest
+	case storage.ErrQuotaExceeded, quota.ErrTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case storage.ErrUnavailable, upload.ErrUnavailable:
+		return http.StatusServiceUnavailable
+	case storage.ErrNotSupported, audit.ErrNotQueryable:
+		return http.StatusNotImplemented
+	case auth.ErrUnauthorized:
+		return http.StatusUnauthorized
+	case auth.ErrForbidden:
+		return http.StatusForbidden
+	}
+
+	return http.StatusInternalServerError
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	writeErrorStatus(w, errorStatus(err), err.Error())
+}
+
+func writeErrorStatus(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, errorMsg{status, msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	blob, err := json.Marshal(v)
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(blob)
+}
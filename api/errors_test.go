@@ -0,0 +1,58 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/appc/acserver/audit"
+	"github.com/appc/acserver/auth"
+	"github.com/appc/acserver/quota"
+	"github.com/appc/acserver/storage"
+	"github.com/appc/acserver/upload"
+)
+
+func TestWriteError(t *testing.T) {
+	for _, tt := range []struct {
+		err    error
+		status int
+	}{
+		{storage.ErrNotFound, http.StatusNotFound},
+		{storage.ErrGPGPubKeyNotProvided, http.StatusNotFound},
+		{upload.ErrNotFound, http.StatusNotFound},
+		{storage.ErrConflict, http.StatusConflict},
+		{upload.ErrConflict, http.StatusConflict},
+		{upload.ErrInvalidTransition, http.StatusConflict},
+		{storage.ErrInvalidName, http.StatusBadRequest},
+		{upload.ErrEmptyName, http.StatusBadRequest},
+		{storage.ErrQuotaExceeded, http.StatusRequestEntityTooLarge},
+		{quota.ErrTooLarge, http.StatusRequestEntityTooLarge},
+		{storage.ErrUnavailable, http.StatusServiceUnavailable},
+		{upload.ErrUnavailable, http.StatusServiceUnavailable},
+		{storage.ErrNotSupported, http.StatusNotImplemented},
+		{audit.ErrNotQueryable, http.StatusNotImplemented},
+		{auth.ErrUnauthorized, http.StatusUnauthorized},
+		{auth.ErrForbidden, http.StatusForbidden},
+		{errors.New("disk on fire"), http.StatusInternalServerError},
+	} {
+		w := httptest.NewRecorder()
+		writeError(w, tt.err)
+
+		msg := errorMsg{}
+
+		if err := json.Unmarshal(w.Body.Bytes(), &msg); err != nil {
+			t.Errorf("%v: %v", tt.err, err)
+			continue
+		}
+
+		if w.Code != tt.status || msg.Status != tt.status || msg.Message != tt.err.Error() {
+			t.Errorf("%v: got %d %+v, expected %d", tt.err, w.Code, msg, tt.status)
+		}
+
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%v: wrong content type %q", tt.err, ct)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/appc/acserver/aci"
+	"github.com/appc/acserver/storage"
+
+	"github.com/appc/acserver/Godeps/_workspace/src/github.com/gorilla/mux"
+)
+
+// latestFile returns the file a download of the latest version of an image
+// should be served from, or an empty string if the requested file isn't a
+// latest version or no release qualifies.
+func (m *Mux) latestFile(file string, preRelease bool) (string, error) {
+	ext := ""
+
+	if strings.HasSuffix(file, aci.SignatureExt) {
+		file, ext = strings.TrimSuffix(file, aci.SignatureExt), aci.SignatureExt
+	}
+
+	img, err := aci.ParseFilename(file)
+
+	if err != nil || img.Version != aci.LatestVersion {
+		return "", nil
+	}
+
+	a, err := m.findImage(img.Name, false)
+
+	if err == storage.ErrNotFound {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	if d := a.Latest(img.OS, img.Arch, img.Ext, preRelease); d != nil {
+		return d.File + ext, nil
+	}
+
+	return "", nil
+}
+
+// latestPlatforms returns the latest release of every platform matching the
+// request.
+func (m *Mux) latestPlatforms(req *http.Request) ([]aci.AciDetails, error) {
+	vars := mux.Vars(req)
+	a, err := m.findImage(vars["name"], false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		res        = []aci.AciDetails{}
+		seen       = map[string]bool{}
+		preRelease = req.URL.Query().Get("prerelease") == "true"
+	)
+
+	for _, d := range a.Details {
+		if os, ok := vars["os"]; ok && d.OS != os {
+			continue
+		}
+
+		if arch, ok := vars["arch"]; ok && d.Arch != arch {
+			continue
+		}
+
+		platform := d.OS + "/" + d.Arch + aci.FileExt(d.File)
+
+		if seen[platform] {
+			continue
+		}
+
+		seen[platform] = true
+
+		if l := a.Latest(d.OS, d.Arch, aci.FileExt(d.File), preRelease); l != nil {
+			res = append(res, *l)
+		}
+	}
+
+	return res, nil
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/appc/acserver/ratelimit"
+)
+
+// busyRetryAfter is suggested to the clients turned down because too many
+// of their, or everyone's, operations are in progress.
+const busyRetryAfter = 5 * time.Second
+
+// statusTooManyRequests isn't defined by net/http before Go 1.6.
+const statusTooManyRequests = 429
+
+// client returns the principal of a request, or the address it comes from
+// for anonymous clients.
+func (m *Mux) client(req *http.Request) string {
+	if p := principal(req); p != "" {
+		return p
+	}
+
+	if p := m.identify(req); p != "" {
+		return p
+	}
+
+	return clientAddr(req)
+}
+
+// rateLimited answers with 429 the clients exceeding the rate of the limiter,
+// if any.
+func (m *Mux) rateLimited(l *ratelimit.Limiter, handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	if l == nil {
+		return handler
+	}
+
+	return func(w http.ResponseWriter, req *http.Request) {
+		if ok, wait := l.Allow(m.client(req)); !ok {
+			writeTooManyRequests(w, wait, "Rate limit exceeded")
+			return
+		}
+
+		handler(w, req)
+	}
+}
+
+// limitTransfers answers with 429 the requests exceeding the number of
+// transfers in progress, if limited.
+func (m *Mux) limitTransfers(handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if m.transfers == nil {
+			handler(w, req)
+			return
+		}
+
+		client := m.client(req)
+
+		if !m.transfers.Acquire(client) {
+			writeTooManyRequests(w, busyRetryAfter, "Too many transfers in progress")
+			return
+		}
+
+		defer m.transfers.Release(client)
+
+		handler(w, req)
+	}
+}
+
+func (m *Mux) closeSession(id uint64) {
+	if m.sessions != nil {
+		m.sessions.Close(id)
+	}
+}
+
+func writeTooManyRequests(w http.ResponseWriter, wait time.Duration, msg string) {
+	w.Header().Set(
+		"Retry-After",
+		strconv.Itoa(int(math.Ceil(wait.Seconds()))),
+	)
+
+	writeErrorStatus(w, statusTooManyRequests, msg)
+}
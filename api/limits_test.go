@@ -0,0 +1,41 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/appc/acserver/ratelimit"
+)
+
+func TestRetryAfter(t *testing.T) {
+	m, _, cleanup := newTestMux(t, Config{
+		UploadRate: ratelimit.NewLimiter(0.5, 1),
+	})
+	defer cleanup()
+
+	start := func(principal string) *httptest.ResponseRecorder {
+		return do(m, principal, "POST", "/example.com/app-1.0.0-linux-amd64.aci/startupload", "")
+	}
+
+	if w := start("bob"); w.Code != http.StatusOK {
+		t.Fatalf("First upload refused: %d", w.Code)
+	}
+
+	w := start("bob")
+
+	if w.Code != statusTooManyRequests {
+		t.Fatalf("Wrong status over the rate: %d", w.Code)
+	}
+
+	// A token every 2 seconds.
+	if s, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || s < 1 || s > 2 {
+		t.Errorf("Wrong Retry-After: %q", w.Header().Get("Retry-After"))
+	}
+
+	// Every client has its own bucket.
+	if w := start("alice"); w.Code != http.StatusOK {
+		t.Errorf("Other client refused: %d", w.Code)
+	}
+}
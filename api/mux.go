@@ -1,17 +1,25 @@
 package api
 
 import (
+	"bytes"
+	"crypto/sha512"
 	"encoding/json"
 	"fmt"
-	"html/template"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"path"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/appc/acserver/aci"
+	"github.com/appc/acserver/audit"
+	"github.com/appc/acserver/auth"
+	"github.com/appc/acserver/events"
+	"github.com/appc/acserver/quota"
+	"github.com/appc/acserver/ratelimit"
 	"github.com/appc/acserver/storage"
 	"github.com/appc/acserver/upload"
 
@@ -21,12 +29,89 @@ import (
 type Mux struct {
 	http.Handler
 
-	store   storage.Storage
-	backend upload.Backend
+	store         storage.Storage
+	backend       upload.Backend
+	authenticator auth.Authenticator
+	events        events.Sink
+	broker        events.Broker
+	audit         audit.Sink
+	admins        map[string]bool
+	uploadRate    *ratelimit.Limiter
+	downloadRate  *ratelimit.Limiter
+	sessions      *ratelimit.Sessions
+	transfers     *ratelimit.Concurrency
+	uploadTimeout time.Duration
+	uploadGrace   time.Duration
+	maxSizes      map[string]int64
+	quotas        *quota.Quotas
+	// publishing serializes the publications checking the quotas, within
+	// this process only: the replicas may exceed them together.
+	publishing sync.Mutex
+	// tagging guards the locks serializing the changes of each tag.
+	tagging  sync.Mutex
+	tagLocks map[string]*tagLock
+
+	index             *templateCache
+	discovery         *templateCache
+	discoveryPrefixes []string
+	resolveLatest     bool
+	serverName        string
+	https             bool
+}
 
-	templateDir string
-	serverName  string
-	https       bool
+type Config struct {
+	Store         storage.Storage
+	Backend       upload.Backend
+	Authenticator auth.Authenticator
+	// Events receives the events of the repository, if set.
+	Events events.Sink
+	// Broker streams the events to the clients of /api/v1/events, if set.
+	Broker events.Broker
+	// Audit records the mutating operations, if set.
+	Audit audit.Sink
+	// Admins lists the principals allowed to use the administration
+	// endpoints and to see or cancel the uploads of the others, none if
+	// empty.
+	Admins []string
+
+	// UploadRate and DownloadRate limit the rate at which each client may
+	// start uploads and download files, if set.
+	UploadRate   *ratelimit.Limiter
+	DownloadRate *ratelimit.Limiter
+	// UploadSessions caps the upload sessions open at once, if set.
+	UploadSessions *ratelimit.Sessions
+	// Transfers caps the ACI uploads in progress at once, if set.
+	Transfers *ratelimit.Concurrency
+	// UploadTimeout is how long uploads may stay open before being
+	// cancelled, forever if 0.
+	UploadTimeout time.Duration
+	// UploadGrace is how long finished uploads are remembered, for the
+	// clients retrying their completion.
+	UploadGrace time.Duration
+
+	// MaxManifestSize, MaxSignatureSize and MaxACISize limit the size of the
+	// uploaded files, if positive.
+	MaxManifestSize  int64
+	MaxSignatureSize int64
+	MaxACISize       int64
+	// Quotas limits the bytes the images of each namespace take, if set.
+	Quotas *quota.Quotas
+
+	TemplateDir string
+	ServerName  string
+	HTTPS       bool
+
+	// ReloadTemplates makes the templates be parsed again when they change,
+	// instead of once.
+	ReloadTemplates bool
+
+	// DiscoveryPrefixes restricts the image names, server name included,
+	// meta discovery is answered for. Every name is served when empty.
+	DiscoveryPrefixes []string
+
+	// ResolveLatest serves the highest semantic version released when the
+	// latest version of an image is requested.
+	ResolveLatest bool
 }
 
 type Handler struct {
@@ -38,8 +123,13 @@ type completeMsg struct {
 	Success      bool   `json:"success"`
 	Reason       string `json:"reason,omitempty"`
 	ServerReason string `json:"server_reason,omitempty"`
+	Digest       string `json:"digest,omitempty"`
 }
 
+// DigestHeader may hold the digest of an uploaded part, as sha512-<hex>,
+// which the upload fails if it doesn't match.
+const DigestHeader = "X-Acserver-Digest"
+
 type initiateDetails struct {
 	ACIPushVersion string `json:"aci_push_version"`
 	Multipart      bool   `json:"multipart"`
@@ -49,41 +139,125 @@ type initiateDetails struct {
 	CompletedURL   string `json:"completed_url"`
 }
 
-func NewServerMux(store storage.Storage, backend upload.Backend, templateDir, serverName string, https bool) *Mux {
+func NewServerMux(cfg Config) *Mux {
 	sm := mux.NewRouter()
-	mux := &Mux{sm, store, backend, templateDir, serverName, https}
+	store := cfg.Store
+	mux := &Mux{
+		Handler:       sm,
+		store:         store,
+		backend:       cfg.Backend,
+		authenticator: cfg.Authenticator,
+		events:        cfg.Events,
+		broker:        cfg.Broker,
+		audit:         cfg.Audit,
+		admins:        make(map[string]bool),
+		tagLocks:      make(map[string]*tagLock),
+		uploadRate:    cfg.UploadRate,
+		downloadRate:  cfg.DownloadRate,
+		sessions:      cfg.UploadSessions,
+		transfers:     cfg.Transfers,
+		uploadTimeout: cfg.UploadTimeout,
+		uploadGrace:   cfg.UploadGrace,
+		maxSizes: map[string]int64{
+			"manifest":  cfg.MaxManifestSize,
+			"signature": cfg.MaxSignatureSize,
+			"aci":       cfg.MaxACISize,
+		},
+		quotas: cfg.Quotas,
+		index: &templateCache{
+			path:   path.Join(cfg.TemplateDir, "index.html"),
+			reload: cfg.ReloadTemplates,
+		},
+		discovery: &templateCache{
+			path:   path.Join(cfg.TemplateDir, "discovery.html"),
+			reload: cfg.ReloadTemplates,
+		},
+		discoveryPrefixes: cfg.DiscoveryPrefixes,
+		resolveLatest:     cfg.ResolveLatest,
+		serverName:        cfg.ServerName,
+		https:             cfg.HTTPS,
+	}
+
+	for _, a := range cfg.Admins {
+		mux.admins[a] = true
+	}
+
+	sm.HandleFunc("/{name:.+}", mux.discover).Queries("ac-discovery", "1")
 
 	for _, couple := range []Handler{
 		Handler{"/", mux.renderACIs},
 		Handler{"/pubkeys.gpg", mux.getPubkeys},
-		Handler{"/{image}/startupload", mux.initiateUpload},
 		Handler{
-			"/manifest/{num}",
-			mux.uploadData(
-				func(u *upload.Upload, req io.Reader) error { return nil },
-				func(u *upload.Upload) { u.GotMan = true },
+			"/{image:.+}/startupload",
+			mux.audited(
+				"startupload",
+				mux.rateLimited(mux.uploadRate, mux.initiateUpload),
 			),
 		},
+		Handler{
+			"/manifest/{num}",
+			mux.audited("upload_manifest", mux.uploadData(
+				"manifest",
+				func(u *upload.Upload, req io.Reader) error {
+					return store.UploadManifest(*u, req)
+				},
+				func(u *upload.Upload, digest string) { u.GotMan = true },
+			)),
+		},
 		Handler{
 			"/signature/{num}",
-			mux.uploadData(
+			mux.audited("upload_signature", mux.uploadData(
+				"signature",
 				func(u *upload.Upload, req io.Reader) error {
 					return store.UploadASC(*u, req)
 				},
-				func(u *upload.Upload) { u.GotSig = true },
-			),
+				func(u *upload.Upload, digest string) { u.GotSig = true },
+			)),
 		},
 		Handler{
 			"/aci/{num}",
-			mux.uploadData(
+			mux.audited("upload_aci", mux.limitTransfers(mux.uploadData(
+				"aci",
 				func(u *upload.Upload, req io.Reader) error {
 					return store.UploadACI(*u, req)
 				},
-				func(u *upload.Upload) { u.GotACI = true },
-			),
+				func(u *upload.Upload, digest string) {
+					u.GotACI, u.Digest = true, digest
+				},
+			))),
+		},
+		Handler{"/complete/{num}", mux.audited("complete", mux.completeUpload)},
+		Handler{"/upload/{num}", mux.upload},
+		Handler{"/api/v1/images", mux.listImages},
+		Handler{"/api/v1/events", mux.streamEvents},
+		Handler{"/api/v1/usage", mux.usage},
+		Handler{"/api/v1/uploads", mux.admin(mux.listUploads)},
+		Handler{"/api/v1/audit", mux.admin(mux.queryAudit)},
+		Handler{
+			"/api/v1/images/{name:.+}/tags/{tag}/rollback",
+			mux.audited("rollback_tag", mux.authenticated(mux.rollbackTag)),
+		},
+		Handler{"/api/v1/images/{name:.+}/tags/{tag}", mux.tag},
+		Handler{
+			"/api/v1/images/{name:.+}/versions/{version}/{os}/{arch}/yank",
+			mux.audited("yank", mux.authenticated(mux.yankImage)),
+		},
+		Handler{
+			"/api/v1/images/{name:.+}/versions/{version}/yank",
+			mux.audited("yank", mux.authenticated(mux.yankImage)),
 		},
-		Handler{"/complete/{num}", mux.completeUpload},
-		Handler{"/{image}", mux.downloadACI},
+		Handler{
+			"/api/v1/images/{name:.+}/versions/{version}/{os}/{arch}",
+			mux.imageVersion,
+		},
+		Handler{"/api/v1/images/{name:.+}/versions/{version}", mux.imageVersion},
+		Handler{"/api/v1/images/{name:.+}", mux.getImage},
+		Handler{"/api/v1/by-id/{id}", mux.lookupID},
+		Handler{
+			"/by-id/{id}",
+			mux.rateLimited(mux.downloadRate, mux.downloadByID),
+		},
+		Handler{"/{image:.+}", mux.rateLimited(mux.downloadRate, mux.downloadACI)},
 	} {
 		sm.HandleFunc(couple.path, couple.handler)
 	}
@@ -97,34 +271,41 @@ func (m *Mux) renderACIs(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	t, err := template.ParseFiles(path.Join(m.templateDir, "index.html"))
+	t, err := m.index.get()
 
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, fmt.Sprintf("%v", err))
+		writeError(w, err)
 		return
 	}
 
-	acis, err := m.store.ListACIs()
+	acis, invalid, err := m.store.ListACIs()
 
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, fmt.Sprintf("%v", err))
+		writeError(w, err)
 		return
 	}
 
-	if err = t.Execute(w, struct {
+	acis = visibleAcis(acis, false)
+	aci.SortAcis(acis)
+	buf := &bytes.Buffer{}
+
+	if err = t.Execute(buf, struct {
 		ServerName string
 		ACIs       []aci.Aci
+		Invalid    []aci.InvalidFile
 		HTTPS      bool
 	}{
 		ServerName: m.serverName,
 		ACIs:       acis,
+		Invalid:    invalid,
 		HTTPS:      m.https,
 	}); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, fmt.Sprintf("%v", err))
+		writeError(w, err)
+		return
 	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	buf.WriteTo(w)
 }
 
 func (m *Mux) getPubkeys(w http.ResponseWriter, req *http.Request) {
@@ -136,21 +317,11 @@ func (m *Mux) getPubkeys(w http.ResponseWriter, req *http.Request) {
 	gpgKey, err := m.store.GetGPGPubKey()
 
 	if err != nil {
-		if err == storage.ErrGPGPubKeyNotProvided {
-			w.WriteHeader(http.StatusNotFound)
-			return
-		} else {
-			fmt.Fprintf(w, "error opening gpg public key: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-	}
-
-	if _, err = w.Write(gpgKey); err != nil {
-		fmt.Fprintf(w, "error reading gpg public key: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
+
+	w.Write(gpgKey)
 }
 
 func (m *Mux) initiateUpload(w http.ResponseWriter, req *http.Request) {
@@ -165,14 +336,53 @@ func (m *Mux) initiateUpload(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	auditing(req).image = image
+
+	if !storage.ValidName(image) {
+		writeError(w, storage.ErrInvalidName)
+		return
+	}
+
+	client := m.client(req)
+
+	if m.sessions != nil {
+		if !m.sessions.Reserve(client) {
+			writeTooManyRequests(w, busyRetryAfter, "Too many uploads in progress")
+			return
+		}
+	}
+
 	upload, err := m.backend.Create(image)
 
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, fmt.Sprintf("%v", err))
+		if m.sessions != nil {
+			m.sessions.Release(client)
+		}
+
+		writeError(w, err)
 		return
 	}
 
+	if m.sessions != nil {
+		m.sessions.Open(client, upload.ID)
+	}
+
+	auditing(req).uploadID = upload.ID
+
+	if upload.Uploader = m.identify(req); upload.Uploader != "" {
+		if err := m.backend.Update(upload); err != nil {
+			m.closeSession(upload.ID)
+			writeError(w, err)
+			return
+		}
+	}
+
+	auditing(req).uploader = upload.Uploader
+
+	e := events.New(events.UploadStarted, upload.Image)
+	e.UploadID, e.Uploader = upload.ID, upload.Uploader
+	m.publish(e)
+
 	var prefix string
 	if m.https {
 		prefix = "https://" + m.serverName
@@ -189,21 +399,10 @@ func (m *Mux) initiateUpload(w http.ResponseWriter, req *http.Request) {
 		CompletedURL:   fmt.Sprintf("%s/complete/%d", prefix, upload.ID),
 	}
 
-	blob, err := json.Marshal(deets)
-
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, fmt.Sprintf("%v", err))
-		return
-	}
-
-	if _, err = w.Write(blob); err != nil {
-		fmt.Fprintf(w, fmt.Sprintf("%v", err))
-		return
-	}
+	writeJSON(w, http.StatusOK, deets)
 }
 
-func (m *Mux) uploadData(uploadData func(*upload.Upload, io.Reader) error, updateUpload func(*upload.Upload)) func(http.ResponseWriter, *http.Request) {
+func (m *Mux) uploadData(part string, uploadData func(*upload.Upload, io.Reader) error, updateUpload func(*upload.Upload, string)) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
 		if req.Method != "PUT" {
 			w.WriteHeader(http.StatusNotFound)
@@ -217,28 +416,96 @@ func (m *Mux) uploadData(uploadData func(*upload.Upload, io.Reader) error, updat
 			return
 		}
 
-		up, err := m.backend.Get(uint64(num))
+		d := auditing(req)
+		d.uploadID = uint64(num)
+
+		up, err := m.getUpload(uint64(num))
 
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, fmt.Sprintf("%v", err))
+			writeError(w, err)
 			return
 		}
 
-		if err := uploadData(up, req.Body); err != nil {
-			fmt.Fprintf(w, fmt.Sprintf("%v", err))
-			w.WriteHeader(http.StatusBadRequest)
+		d.image, d.uploader = up.Image, up.Uploader
+
+		// Refuse the parts of the uploads being completed before storing
+		// anything.
+		up, err = m.changeUpload(up.ID, func(up *upload.Upload) error {
+			return up.Transition(upload.Receiving)
+		})
+
+		if err != nil {
+			writeError(w, err)
 			return
 		}
 
-		updateUpload(up)
+		body, err := m.limitUpload(part, up, req.Body)
 
-		if err := m.backend.Update(up); err != nil {
-			fmt.Fprintf(w, fmt.Sprintf("%v", err))
-			w.WriteHeader(http.StatusBadRequest)
+		if err != nil {
+			writeError(w, err)
 			return
 		}
 
+		// Announced sizes are refused before receiving anything.
+		if req.ContentLength > body.N {
+			m.reportFailure(up, w, req, http.StatusRequestEntityTooLarge, body.Err.Error(), "")
+			return
+		}
+
+		limit := body.N
+		h := sha512.New()
+
+		if err := uploadData(up, io.TeeReader(body, h)); err != nil {
+			if body.Exceeded {
+				m.reportFailure(up, w, req, http.StatusRequestEntityTooLarge, body.Err.Error(), "")
+				return
+			}
+
+			writeError(w, err)
+			return
+		}
+
+		// The limit went down by the number of bytes read.
+		received := limit - body.N
+		digest := storage.FormatDigest(h.Sum(nil))
+
+		if expected := req.Header.Get(DigestHeader); expected != "" && expected != digest {
+			m.reportFailure(
+				up,
+				w,
+				req,
+				http.StatusBadRequest,
+				fmt.Sprintf("%s received, %s expected", digest, expected),
+				"",
+			)
+			return
+		}
+
+		up, err = m.changeUpload(up.ID, func(up *upload.Upload) error {
+			if err := up.Transition(upload.Receiving); err != nil {
+				return err
+			}
+
+			updateUpload(up, digest)
+
+			if up.Received == nil {
+				up.Received = make(map[string]int64)
+			}
+
+			up.Received[part] = received
+
+			return nil
+		})
+
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		e := events.New(events.PartReceived, up.Image)
+		e.UploadID, e.Uploader, e.Part = up.ID, up.Uploader, part
+		m.publish(e)
+
 		w.WriteHeader(http.StatusOK)
 	}
 }
@@ -251,15 +518,66 @@ func (m *Mux) downloadACI(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if m.resolveLatest {
+		f, err := m.latestFile(image, req.URL.Query().Get("prerelease") == "true")
+
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		if f != "" {
+			http.Redirect(w, req, "/"+f, http.StatusFound)
+			return
+		}
+	}
+
+	m.serveFile(w, req, image)
+}
+
+// serveFile sends a stored file, or redirects to a presigned URL of it.
+func (m *Mux) serveFile(w http.ResponseWriter, req *http.Request, image string) {
+	info, err := m.store.StatACI(image)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if p, ok := m.store.(storage.Presigner); ok {
+		u, err := p.PresignURL(image)
+
+		switch err {
+		case nil:
+			http.Redirect(w, req, u, http.StatusFound)
+			return
+		case storage.ErrNotSupported:
+		default:
+			writeError(w, err)
+			return
+		}
+	}
+
 	rs, err := m.store.DownloadACI(image)
 
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, fmt.Sprintf("%v", err))
+		writeError(w, err)
 		return
 	}
 
-	http.ServeContent(w, req, image, time.Now(), rs)
+	defer rs.Close()
+
+	if strings.HasSuffix(image, aci.SignatureExt) {
+		w.Header().Set("Content-Type", "application/pgp-signature")
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+
+	if info.Digest != "" {
+		w.Header().Set("ETag", strconv.Quote(info.Digest))
+	}
+
+	http.ServeContent(w, req, image, info.ModTime, rs)
 }
 
 func (m *Mux) completeUpload(w http.ResponseWriter, req *http.Request) {
@@ -275,97 +593,156 @@ func (m *Mux) completeUpload(w http.ResponseWriter, req *http.Request) {
 	}
 
 	num := uint64(numInt)
+	d := auditing(req)
+	d.uploadID = num
 
-	up, err := m.backend.Get(uint64(num))
+	up, err := m.getUpload(uint64(num))
 
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, fmt.Sprintf("%v", err))
+		writeError(w, err)
+		return
+	}
+
+	d.image, d.uploader = up.Image, up.Uploader
+
+	// Retried completions get the outcome of the first one.
+	if up.State.Done() {
+		writeOutcome(w, up)
 		return
 	}
 
 	body, err := ioutil.ReadAll(req.Body)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, fmt.Sprintf("%v", err))
+		writeError(w, err)
 		return
 	}
 
 	msg := completeMsg{}
 
 	if err = json.Unmarshal(body, &msg); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, fmt.Sprintf("%v", err))
+		writeErrorStatus(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	if !msg.Success {
-		m.reportFailure(up, w, "client reported failure", msg.Reason)
+		d.action = "cancel"
+		m.reportFailure(up, w, req, http.StatusOK, "client reported failure", msg.Reason)
+		return
+	}
+
+	up, err = m.changeUpload(num, func(up *upload.Upload) error {
+		return up.Transition(upload.Verifying)
+	})
+
+	if err != nil {
+		writeError(w, err)
 		return
 	}
 
 	if !up.GotMan {
-		m.reportFailure(up, w, "manifest wasn't uploaded", msg.Reason)
+		m.reportFailure(up, w, req, http.StatusOK, "manifest wasn't uploaded", msg.Reason)
 		return
 	}
 
 	if !up.GotSig {
-		m.reportFailure(up, w, "signature wasn't uploaded", msg.Reason)
+		m.reportFailure(up, w, req, http.StatusOK, "signature wasn't uploaded", msg.Reason)
 		return
 	}
 
 	if !up.GotACI {
-		m.reportFailure(up, w, "ACI wasn't uploaded", msg.Reason)
+		m.reportFailure(up, w, req, http.StatusOK, "ACI wasn't uploaded", msg.Reason)
 		return
 	}
 
 	//TODO: image verification here
 
-	if err = m.store.FinishUpload(*up); err != nil {
-		m.reportFailure(up, w, "Internal Server Error", msg.Reason)
+	up, err = m.changeUpload(num, func(up *upload.Upload) error {
+		return up.Transition(upload.Publishing)
+	})
+
+	if err != nil {
+		writeError(w, err)
 		return
-	} else {
-		m.backend.Delete(up.ID)
 	}
 
-	blob, err := json.Marshal(completeMsg{Success: true})
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, fmt.Sprintf("%v", err))
+	if err = m.finishUpload(up); err != nil {
+		m.reportFailure(up, w, req, errorStatus(err), err.Error(), msg.Reason)
 		return
 	}
 
-	if _, err = w.Write(blob); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, fmt.Sprintf("%v", err))
+	up, err = m.changeUpload(num, func(up *upload.Upload) error {
+		return up.Transition(upload.Published)
+	})
+
+	if err != nil {
+		writeError(w, err)
 		return
 	}
+
+	m.closeSession(up.ID)
+
+	e := events.New(events.PushCompleted, up.Image)
+	e.UploadID, e.Uploader, e.Digest = up.ID, up.Uploader, up.Digest
+	d.digest = up.Digest
+	m.publish(e)
+
+	writeOutcome(w, up)
 }
 
-func (m *Mux) reportFailure(up *upload.Upload, w http.ResponseWriter, msg, clientmsg string) {
-	err := m.backend.Delete(up.ID)
+// finishUpload publishes the files of an upload, provided they still fit in
+// the quota of their namespace.
+func (m *Mux) finishUpload(up *upload.Upload) error {
+	if m.quotaApplies(up) {
+		m.publishing.Lock()
+		defer m.publishing.Unlock()
+	}
 
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, fmt.Sprintf("%v", err))
+	if err := m.checkQuota(up); err != nil {
+		return err
 	}
 
-	m.store.CancelUpload(*up)
+	return m.store.FinishUpload(*up)
+}
+
+func (m *Mux) reportFailure(up *upload.Upload, w http.ResponseWriter, req *http.Request, status int, msg, clientmsg string) {
+	auditing(req).err = msg
 
-	failmsg := completeMsg{
-		Success:      false,
-		Reason:       clientmsg,
-		ServerReason: msg,
+	if _, err := m.cancelUpload(up.ID, msg, clientmsg); err != nil {
+		writeError(w, err)
+		return
 	}
 
-	blob, err := json.Marshal(failmsg)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, fmt.Sprintf("%v", err))
+	writeJSON(
+		w,
+		status,
+		completeMsg{
+			Success:      false,
+			Reason:       clientmsg,
+			ServerReason: msg,
+		},
+	)
+}
+
+// writeOutcome answers a completion with the outcome of a finished upload.
+func writeOutcome(w http.ResponseWriter, up *upload.Upload) {
+	writeJSON(
+		w,
+		http.StatusOK,
+		completeMsg{
+			Success:      up.State == upload.Published,
+			Reason:       up.Reason,
+			ServerReason: up.ServerReason,
+			Digest:       up.Digest,
+		},
+	)
+}
+
+func (m *Mux) publish(e *events.Event) {
+	if m.events != nil {
+		m.events.Publish(e)
 	}
 
-	if _, err = w.Write(blob); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, fmt.Sprintf("%v", err))
+	if m.broker != nil {
+		m.broker.Publish(e)
 	}
 }
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/appc/acserver/auth"
+	"github.com/appc/acserver/storage/filesystem"
+	"github.com/appc/acserver/upload/memory"
+)
+
+// tokens authenticates the principals it knows with "Bearer <principal>".
+type tokens map[string]bool
+
+func (t tokens) Authenticate(req *http.Request) (string, error) {
+	p := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+
+	if !t[p] {
+		return "", auth.ErrUnauthorized
+	}
+
+	return p, nil
+}
+
+// newTestMux serves a filesystem storage and a memory upload backend, the
+// fields of the configuration left empty. The principals alice and bob are
+// known, alice is the administrator.
+func newTestMux(t *testing.T, cfg Config) (*Mux, *filesystem.Storage, func()) {
+	dir, err := ioutil.TempDir("", "acserver-api")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err := filesystem.NewStorage(dir, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Store == nil {
+		cfg.Store = fs
+	}
+
+	if cfg.Backend == nil {
+		cfg.Backend, _ = memory.NewBackend()
+	}
+
+	if cfg.Authenticator == nil {
+		cfg.Authenticator = tokens{"alice": true, "bob": true}
+	}
+
+	if cfg.Admins == nil {
+		cfg.Admins = []string{"alice"}
+	}
+
+	cfg.ServerName = "example.com"
+
+	return NewServerMux(cfg), fs, func() { os.RemoveAll(dir) }
+}
+
+// do sends a request as a principal, anonymously if empty.
+func do(m *Mux, principal, method, url, body string) *httptest.ResponseRecorder {
+	var r io.Reader
+
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+
+	req, _ := http.NewRequest(method, url, r)
+	req.RemoteAddr = "192.0.2.1:1234"
+
+	if principal != "" {
+		req.Header.Set("Authorization", "Bearer "+principal)
+	}
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestHiddenFiles(t *testing.T) {
+	m, fs, cleanup := newTestMux(t, Config{})
+	defer cleanup()
+
+	if err := fs.PutMetadata("tags/example.com/app/latest.json", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, u := range []string{
+		"/.metadata/tags/example.com/app/latest.json",
+		"/example.com/.hidden",
+		"/.blobs",
+	} {
+		if w := do(m, "", "GET", u, ""); w.Code == http.StatusOK {
+			t.Errorf("%s: served: %q", u, w.Body.String())
+		}
+	}
+}
+
+// send starts an upload of a version of an image for linux/amd64 and sends
+// its parts, the ACI holding size bytes, returning the upload ID.
+func send(t *testing.T, m *Mux, principal, name, version string, size int) string {
+	image := fmt.Sprintf("%s-%s-linux-amd64.aci", name, version)
+	w := do(m, principal, "POST", "/"+image+"/startupload", "")
+	details := initiateDetails{}
+
+	if err := json.Unmarshal(w.Body.Bytes(), &details); err != nil {
+		t.Fatalf("%s: %v: %s", image, err, w.Body.String())
+	}
+
+	id := path.Base(details.CompletedURL)
+	manifest := fmt.Sprintf(`{"acKind": "ImageManifest", "name": %q, "labels": [
+		{"name": "version", "value": %q},
+		{"name": "os", "value": "linux"},
+		{"name": "arch", "value": "amd64"}
+	]}`, name, version)
+
+	do(m, principal, "PUT", "/manifest/"+id, manifest)
+	do(m, principal, "PUT", "/signature/"+id, "signature")
+	do(m, principal, "PUT", "/aci/"+id, strings.Repeat("a", size))
+
+	return id
+}
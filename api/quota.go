@@ -0,0 +1,115 @@
+package api
+
+import (
+	"io"
+	"math"
+	"net/http"
+
+	"github.com/appc/acserver/aci"
+	"github.com/appc/acserver/quota"
+	"github.com/appc/acserver/storage"
+	"github.com/appc/acserver/upload"
+)
+
+// limitUpload bounds a part of an upload to its maximum size and, for ACIs,
+// to what the quota of their namespace has left.
+func (m *Mux) limitUpload(part string, up *upload.Upload, body io.Reader) (*quota.LimitedReader, error) {
+	r := quota.LimitReader(body, math.MaxInt64)
+
+	if max := m.maxSizes[part]; max > 0 {
+		r.N = max
+	}
+
+	if part != "aci" || !m.quotaApplies(up) {
+		return r, nil
+	}
+
+	left, ok, err := m.remaining(up)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if ok && left < r.N {
+		r.N, r.Err = left, storage.ErrQuotaExceeded
+	}
+
+	return r, nil
+}
+
+// checkQuota verifies again, before publishing, that the ACI of an upload
+// fits in what the quota of its namespace has left: the uploads sent at once
+// were each limited to what was left when they started. The caller holds
+// m.publishing.
+func (m *Mux) checkQuota(up *upload.Upload) error {
+	if !m.quotaApplies(up) {
+		return nil
+	}
+
+	left, ok, err := m.remaining(up)
+
+	if err != nil {
+		return err
+	}
+
+	if ok && left < up.Received["aci"] {
+		return storage.ErrQuotaExceeded
+	}
+
+	return nil
+}
+
+// quotaApplies reports whether the namespace of an upload has a quota, the
+// images being listed to enforce it.
+func (m *Mux) quotaApplies(up *upload.Upload) bool {
+	return m.quotas != nil && m.quotas.Applies(up.Image)
+}
+
+// remaining returns what the quota of the namespace of an upload has left,
+// not counting the file it replaces. It lists every image, the cache makes it
+// cheap.
+func (m *Mux) remaining(up *upload.Upload) (int64, bool, error) {
+	acis, _, err := m.store.ListACIs()
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	for i, a := range acis {
+		details := []aci.AciDetails{}
+
+		for _, d := range a.Details {
+			if d.File != up.Image {
+				details = append(details, d)
+			}
+		}
+
+		acis[i].Details = details
+	}
+
+	left, ok := m.quotas.Remaining(acis, up.Image)
+
+	return left, ok, nil
+}
+
+func (m *Mux) usage(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	acis, _, err := m.store.ListACIs()
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	q := m.quotas
+
+	if q == nil {
+		q = quota.NewQuotas(nil)
+	}
+
+	writeJSON(w, http.StatusOK, q.Usage(acis))
+}
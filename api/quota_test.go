@@ -0,0 +1,93 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appc/acserver/quota"
+)
+
+func TestQuotaCheckedOnCompletion(t *testing.T) {
+	m, _, cleanup := newTestMux(t, Config{
+		Quotas: quota.NewQuotas([]quota.Rule{{Namespace: "example.com", Bytes: 100}}),
+	})
+	defer cleanup()
+
+	complete := func(id string) bool {
+		w := do(m, "bob", "POST", "/complete/"+id, `{"success": true}`)
+		msg := completeMsg{}
+
+		if err := json.Unmarshal(w.Body.Bytes(), &msg); err != nil {
+			t.Fatalf("%v: %s", err, w.Body.String())
+		}
+
+		return msg.Success
+	}
+
+	// Both uploads fit in the quota when they are sent, not together.
+	first := send(t, m, "bob", "example.com/app", "1.0.0", 60)
+	second := send(t, m, "bob", "example.com/app", "1.1.0", 60)
+
+	if !complete(first) {
+		t.Fatal("First upload refused")
+	}
+
+	if complete(second) {
+		t.Error("Second upload published over the quota")
+	}
+
+	// Pushing a file again only counts its new size.
+	if !complete(send(t, m, "bob", "example.com/app", "1.0.0", 90)) {
+		t.Error("Replacement refused")
+	}
+}
+
+func TestOversizeParts(t *testing.T) {
+	m, _, cleanup := newTestMux(t, Config{MaxACISize: 10, UploadGrace: time.Hour})
+	defer cleanup()
+
+	for _, announced := range []bool{true, false} {
+		w := do(m, "bob", "POST", "/example.com/app-1.0.0-linux-amd64.aci/startupload", "")
+		details := initiateDetails{}
+
+		if err := json.Unmarshal(w.Body.Bytes(), &details); err != nil {
+			t.Fatal(err)
+		}
+
+		id := path.Base(details.CompletedURL)
+
+		// Without a length, the upload is cut once the limit is reached.
+		var body io.Reader = strings.NewReader(strings.Repeat("a", 20))
+
+		if !announced {
+			body = io.MultiReader(body)
+		}
+
+		req, _ := http.NewRequest("PUT", "/aci/"+id, body)
+		req.RemoteAddr = "192.0.2.1:1234"
+		w = httptest.NewRecorder()
+		m.ServeHTTP(w, req)
+
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("announced %v: wrong status %d", announced, w.Code)
+		}
+
+		w = do(m, "bob", "GET", "/upload/"+id, "")
+		up := struct{ State string }{}
+		json.Unmarshal(w.Body.Bytes(), &up)
+
+		if up.State != "failed" {
+			t.Errorf("announced %v: upload not cancelled: %s", announced, w.Body.String())
+		}
+
+		if w := do(m, "bob", "PUT", "/manifest/"+id, "{}"); w.Code != http.StatusConflict {
+			t.Errorf("announced %v: part of a cancelled upload got %d", announced, w.Code)
+		}
+	}
+}
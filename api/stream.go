@@ -0,0 +1,161 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/appc/acserver/events"
+	"github.com/appc/acserver/storage"
+)
+
+// keepAliveInterval is how often a comment is sent to idle streams so that
+// proxies don't close them.
+const keepAliveInterval = 15 * time.Second
+
+// streamEvents sends the events as Server-Sent Events. Clients resume from
+// the Last-Event-ID header, or the last_event_id parameter, and may filter
+// the events by type, image name prefix or upload ID. The principals and the
+// server side failure reasons are only sent to authenticated clients.
+func (m *Mux) streamEvents(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if m.broker == nil {
+		writeError(w, storage.ErrNotSupported)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+
+	if !ok {
+		writeError(w, storage.ErrNotSupported)
+		return
+	}
+
+	q := req.URL.Query()
+	lastID := req.Header.Get("Last-Event-ID")
+
+	if lastID == "" {
+		lastID = q.Get("last_event_id")
+	}
+
+	after, err := uintParam(lastID)
+
+	if err != nil {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid Last-Event-ID")
+		return
+	}
+
+	uploadID, err := uintParam(q.Get("upload"))
+
+	if err != nil {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid upload")
+		return
+	}
+
+	filter := func(e *events.Event) bool {
+		if uploadID != 0 && e.UploadID != uploadID {
+			return false
+		}
+
+		if !strings.HasPrefix(e.Name, q.Get("name")) {
+			return false
+		}
+
+		if types := q["type"]; len(types) > 0 {
+			for _, t := range types {
+				if events.Type(t) == e.Type {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		return true
+	}
+
+	anonymous := m.identify(req) == ""
+
+	sub, err := m.broker.Subscribe(after)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	for _, e := range sub.Backlog {
+		if filter(e) {
+			writeEvent(w, e, anonymous)
+		}
+	}
+
+	flusher.Flush()
+
+	var closed <-chan bool
+
+	if n, ok := w.(http.CloseNotifier); ok {
+		closed = n.CloseNotify()
+	}
+
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				// Too far behind, the client reconnects and resumes.
+				return
+			}
+
+			if !filter(e) {
+				continue
+			}
+
+			writeEvent(w, e, anonymous)
+		case <-ticker.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-closed:
+			return
+		}
+
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e *events.Event, anonymous bool) {
+	if anonymous {
+		stripped := *e
+		stripped.Uploader, stripped.Principal, stripped.ServerReason = "", "", ""
+		e = &stripped
+	}
+
+	blob, err := json.Marshal(e)
+
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, blob)
+}
+
+func uintParam(v string) (uint64, error) {
+	if v == "" {
+		return 0, nil
+	}
+
+	return strconv.ParseUint(v, 10, 64)
+}
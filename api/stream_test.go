@@ -0,0 +1,66 @@
+package api
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/appc/acserver/events"
+	"github.com/appc/acserver/events/memory"
+)
+
+func TestStreamStripsPrincipals(t *testing.T) {
+	broker, _ := memory.NewBroker(10)
+	m, _, cleanup := newTestMux(t, Config{Broker: broker})
+	defer cleanup()
+
+	srv := httptest.NewServer(m)
+	defer srv.Close()
+
+	for i := 0; i < 2; i++ {
+		e := events.New(events.PushFailed, "example.com/app-1.0.0-linux-amd64.aci")
+		e.Uploader, e.Principal, e.ServerReason = "alice", "alice", "disk full"
+		broker.Publish(e)
+	}
+
+	for _, tt := range []struct {
+		principal string
+		stripped  bool
+	}{
+		{"", true},
+		{"bob", false},
+	} {
+		req, _ := http.NewRequest("GET", srv.URL+"/api/v1/events?last_event_id=1", nil)
+
+		if tt.principal != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.principal)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data := ""
+		scanner := bufio.NewScanner(resp.Body)
+
+		for data == "" && scanner.Scan() {
+			if strings.HasPrefix(scanner.Text(), "data: ") {
+				data = scanner.Text()
+			}
+		}
+
+		resp.Body.Close()
+
+		if data == "" {
+			t.Fatalf("%q: no event received", tt.principal)
+		}
+
+		if tt.stripped == strings.Contains(data, "alice") || tt.stripped == strings.Contains(data, "disk full") {
+			t.Errorf("%q: wrong event: %s", tt.principal, data)
+		}
+	}
+}
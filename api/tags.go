@@ -0,0 +1,307 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/appc/acserver/aci"
+	"github.com/appc/acserver/events"
+	"github.com/appc/acserver/semver"
+	"github.com/appc/acserver/storage"
+
+	"github.com/appc/acserver/Godeps/_workspace/src/github.com/gorilla/mux"
+)
+
+type tagRecord struct {
+	Version   string    `json:"version"`
+	Principal string    `json:"principal,omitempty"`
+	Time      time.Time `json:"time"`
+	// Rollback marks the records undoing the change before them.
+	Rollback bool `json:"rollback,omitempty"`
+}
+
+type tagDetails struct {
+	Name    string      `json:"name"`
+	Tag     string      `json:"tag"`
+	Version string      `json:"version"`
+	History []tagRecord `json:"history"`
+}
+
+type tagRequest struct {
+	Version string `json:"version"`
+}
+
+func (m *Mux) tag(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case "GET":
+		m.getTag(w, req)
+	case "PUT":
+		m.audited("tag", m.authenticated(m.putTag))(w, req)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (m *Mux) getTag(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	history, err := m.tagHistory(vars["name"], vars["tag"])
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if len(history) == 0 {
+		writeErrorStatus(w, http.StatusNotFound, "Tag not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newTagDetails(vars["name"], vars["tag"], history))
+}
+
+func (m *Mux) putTag(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	r := tagRequest{}
+
+	if err := json.NewDecoder(req.Body).Decode(&r); err != nil || r.Version == "" {
+		writeErrorStatus(w, http.StatusBadRequest, "expected {\"version\": \"...\"}")
+		return
+	}
+
+	m.applyTag(w, req, vars["name"], vars["tag"], r.Version, false)
+}
+
+func (m *Mux) rollbackTag(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	vars := mux.Vars(req)
+	m.applyTag(w, req, vars["name"], vars["tag"], "", true)
+}
+
+// previousVersion returns the version a rollback points the tag back at,
+// successive rollbacks stepping further back through the changes not undone
+// yet. It returns an empty string if there is none.
+func previousVersion(history []tagRecord) string {
+	versions := []string{}
+
+	for _, r := range history {
+		if r.Rollback && len(versions) > 0 {
+			versions = versions[:len(versions)-1]
+		} else {
+			versions = append(versions, r.Version)
+		}
+	}
+
+	if len(versions) < 2 {
+		return ""
+	}
+
+	return versions[len(versions)-2]
+}
+
+// applyTag points every platform of the tag at the given version, removes the
+// platforms the version doesn't have and records the change in the history.
+// Rollbacks point the tag at the previous version in the history.
+func (m *Mux) applyTag(w http.ResponseWriter, req *http.Request, name, tag, version string, rollback bool) {
+	d := auditing(req)
+	d.image, d.tag = name, tag
+
+	// Checked before changing the files, the history couldn't be recorded.
+	md, err := m.metadata()
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// The history is read, extended and written back while the tag is
+	// locked, the changes of other requests would be lost otherwise.
+	defer m.lockTag(tagHistoryKey(name, tag))()
+
+	history, err := m.tagHistory(name, tag)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if rollback {
+		if version = previousVersion(history); version == "" {
+			writeErrorStatus(w, http.StatusConflict, "No previous version to roll back to")
+			return
+		}
+	}
+
+	d.version = version
+
+	if _, err := semver.Parse(tag); err == nil || tag == version {
+		writeErrorStatus(w, http.StatusBadRequest, "Tags can't be versions")
+		return
+	}
+
+	a, err := m.findImage(name, false)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	found := false
+
+	for _, d := range a.Details {
+		found = found || d.Version == version
+	}
+
+	if !found {
+		writeError(w, storage.ErrNotFound)
+		return
+	}
+
+	history = append(
+		history,
+		tagRecord{
+			Version:   version,
+			Principal: principal(req),
+			Time:      time.Now(),
+			Rollback:  rollback,
+		},
+	)
+
+	blob, err := json.Marshal(history)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Recorded first, the files then pointing at a version the history
+	// knows even if changing them fails half way.
+	if err := md.PutMetadata(tagHistoryKey(name, tag), blob); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	tagged := map[string]bool{}
+
+	for _, d := range a.Details {
+		if d.Version != version {
+			continue
+		}
+
+		dst := aci.Filename(a.Name, tag, d.OS, d.Arch, aci.FileExt(d.File))
+
+		if err := m.store.TagACI(d.File, dst); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		tagged[dst] = true
+
+		e := events.New(events.ImageTagged, d.File)
+		e.Tag, e.Principal = tag, principal(req)
+		m.publish(e)
+	}
+
+	for _, d := range a.Details {
+		if d.Version != tag || tagged[d.File] {
+			continue
+		}
+
+		if err := m.store.DeleteACI(d.File); err == storage.ErrNotFound {
+			continue
+		} else if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		e := events.New(events.ImageDeleted, d.File)
+		e.Principal = principal(req)
+		m.publish(e)
+	}
+
+	writeJSON(w, http.StatusOK, newTagDetails(name, tag, history))
+}
+
+type tagLock struct {
+	sync.Mutex
+	users int
+}
+
+// lockTag locks the changes of the tag whose history is stored under a key,
+// and returns the function unlocking it. The locks are only kept while used.
+func (m *Mux) lockTag(key string) func() {
+	m.tagging.Lock()
+	l, ok := m.tagLocks[key]
+
+	if !ok {
+		l = &tagLock{}
+		m.tagLocks[key] = l
+	}
+
+	l.users++
+	m.tagging.Unlock()
+
+	l.Lock()
+
+	return func() {
+		l.Unlock()
+
+		m.tagging.Lock()
+		defer m.tagging.Unlock()
+
+		if l.users--; l.users == 0 {
+			delete(m.tagLocks, key)
+		}
+	}
+}
+
+func (m *Mux) tagHistory(name, tag string) ([]tagRecord, error) {
+	history := []tagRecord{}
+	md, err := m.metadata()
+
+	if err != nil {
+		return nil, err
+	}
+
+	blob, err := md.GetMetadata(tagHistoryKey(name, tag))
+
+	if err == storage.ErrNotFound {
+		return history, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(blob, &history); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+// metadata returns the store of the tag histories, the storage itself when it
+// supports it.
+func (m *Mux) metadata() (storage.MetadataStore, error) {
+	if md, ok := m.store.(storage.MetadataStore); ok {
+		return md, nil
+	}
+
+	return nil, storage.ErrNotSupported
+}
+
+func tagHistoryKey(name, tag string) string {
+	return "tags/" + name + "/" + tag + ".json"
+}
+
+func newTagDetails(name, tag string, history []tagRecord) tagDetails {
+	return tagDetails{
+		Name:    name,
+		Tag:     tag,
+		Version: history[len(history)-1].Version,
+		History: history,
+	}
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/appc/acserver/storage/filesystem"
+)
+
+func TestPreviousVersion(t *testing.T) {
+	set := func(v string) tagRecord { return tagRecord{Version: v} }
+	rollback := func(v string) tagRecord { return tagRecord{Version: v, Rollback: true} }
+
+	for _, tt := range []struct {
+		history []tagRecord
+		version string
+	}{
+		{[]tagRecord{}, ""},
+		{[]tagRecord{set("1")}, ""},
+		{[]tagRecord{set("1"), set("2"), set("3")}, "2"},
+		{[]tagRecord{set("1"), set("2"), set("3"), rollback("2")}, "1"},
+		{[]tagRecord{set("1"), set("2"), set("3"), rollback("2"), rollback("1")}, ""},
+		{[]tagRecord{set("1"), set("2"), rollback("1"), set("3")}, "1"},
+	} {
+		if v := previousVersion(tt.history); v != tt.version {
+			t.Errorf("%+v: wrong version: %q", tt.history, v)
+		}
+	}
+}
+
+// slowMetadata reads the metadata slowly, concurrent changes overlapping.
+type slowMetadata struct {
+	*filesystem.Storage
+}
+
+func (s slowMetadata) GetMetadata(key string) ([]byte, error) {
+	time.Sleep(10 * time.Millisecond)
+
+	return s.Storage.GetMetadata(key)
+}
+
+func TestConcurrentTags(t *testing.T) {
+	m, fs, cleanup := newTestMux(t, Config{})
+	defer cleanup()
+
+	m.store = slowMetadata{fs}
+
+	for _, v := range []string{"1.0.0", "2.0.0"} {
+		if w := do(m, "bob", "POST", "/complete/"+send(t, m, "bob", "example.com/app", v, 3), `{"success": true}`); w.Code != http.StatusOK {
+			t.Fatalf("Upload failed: %s", w.Body.String())
+		}
+	}
+
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func(v string) {
+			defer wg.Done()
+
+			if w := do(m, "bob", "PUT", "/api/v1/images/example.com/app/tags/stable", `{"version": "`+v+`"}`); w.Code != http.StatusOK {
+				t.Errorf("Tag failed: %d %s", w.Code, w.Body.String())
+			}
+		}([]string{"1.0.0", "2.0.0"}[i%2])
+	}
+
+	wg.Wait()
+
+	// Unknown versions aren't recorded.
+	if w := do(m, "bob", "PUT", "/api/v1/images/example.com/app/tags/stable", `{"version": "3.0.0"}`); w.Code != http.StatusNotFound {
+		t.Errorf("Wrong status for an unknown version: %d", w.Code)
+	}
+
+	details := tagDetails{}
+	w := do(m, "", "GET", "/api/v1/images/example.com/app/tags/stable", "")
+
+	if err := json.Unmarshal(w.Body.Bytes(), &details); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(details.History) != 10 {
+		t.Errorf("Changes lost: %d recorded", len(details.History))
+	}
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"html/template"
+	"os"
+	"sync"
+	"time"
+)
+
+// templateCache parses a template once, or again whenever the file changes
+// when reloading is enabled for development.
+type templateCache struct {
+	path   string
+	reload bool
+
+	mu      sync.Mutex
+	tmpl    *template.Template
+	modTime time.Time
+}
+
+func (c *templateCache) get() (*template.Template, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.tmpl != nil && !c.reload {
+		return c.tmpl, nil
+	}
+
+	fi, err := os.Stat(c.path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if c.tmpl != nil && fi.ModTime().Equal(c.modTime) {
+		return c.tmpl, nil
+	}
+
+	t, err := template.ParseFiles(c.path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.tmpl, c.modTime = t, fi.ModTime()
+
+	return t, nil
+}
@@ -0,0 +1,253 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/appc/acserver/auth"
+	"github.com/appc/acserver/events"
+	"github.com/appc/acserver/upload"
+
+	"github.com/appc/acserver/Godeps/_workspace/src/github.com/gorilla/mux"
+)
+
+// changeRetries bounds the attempts at changing an upload modified
+// concurrently.
+const changeRetries = 5
+
+type uploadDetails struct {
+	ID           uint64           `json:"id"`
+	Image        string           `json:"image"`
+	Uploader     string           `json:"uploader,omitempty"`
+	State        upload.State     `json:"state"`
+	Manifest     bool             `json:"manifest"`
+	Signature    bool             `json:"signature"`
+	ACI          bool             `json:"aci"`
+	Received     map[string]int64 `json:"received"`
+	Digest       string           `json:"digest,omitempty"`
+	Reason       string           `json:"reason,omitempty"`
+	ServerReason string           `json:"server_reason,omitempty"`
+	Started      time.Time        `json:"started"`
+	Updated      time.Time        `json:"updated"`
+	Expires      *time.Time       `json:"expires,omitempty"`
+}
+
+func (m *Mux) newUploadDetails(up *upload.Upload) uploadDetails {
+	d := uploadDetails{
+		ID:           up.ID,
+		Image:        up.Image,
+		Uploader:     up.Uploader,
+		State:        up.State,
+		Manifest:     up.GotMan,
+		Signature:    up.GotSig,
+		ACI:          up.GotACI,
+		Received:     up.Received,
+		Digest:       up.Digest,
+		Reason:       up.Reason,
+		ServerReason: up.ServerReason,
+		Started:      up.Started,
+		Updated:      up.Updated,
+	}
+
+	if d.Received == nil {
+		d.Received = map[string]int64{}
+	}
+
+	if m.uploadTimeout > 0 && !up.State.Done() {
+		expires := up.Started.Add(m.uploadTimeout)
+		d.Expires = &expires
+	}
+
+	return d
+}
+
+// getUpload returns an upload, failing it first if it expired. The finished
+// uploads are forgotten after the grace period.
+func (m *Mux) getUpload(id uint64) (*upload.Upload, error) {
+	up, err := m.backend.Get(id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case up.State.Done():
+		if time.Since(up.Updated) > m.uploadGrace {
+			if err := m.backend.Delete(id); err != nil && err != upload.ErrNotFound {
+				return nil, err
+			}
+
+			return nil, upload.ErrNotFound
+		}
+	case m.uploadTimeout > 0 && time.Since(up.Started) > m.uploadTimeout:
+		return m.cancelUpload(id, "upload expired", "")
+	}
+
+	return up, nil
+}
+
+// changeUpload applies a change to the latest record of an upload, again if
+// it was modified concurrently.
+func (m *Mux) changeUpload(id uint64, change func(*upload.Upload) error) (*upload.Upload, error) {
+	for i := 1; ; i++ {
+		up, err := m.backend.Get(id)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := change(up); err != nil {
+			return nil, err
+		}
+
+		err = m.backend.Update(up)
+
+		if err == upload.ErrConflict && i < changeRetries {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		return up, nil
+	}
+}
+
+// cancelUpload fails an upload and discards the files received for it.
+func (m *Mux) cancelUpload(id uint64, msg, clientmsg string) (*upload.Upload, error) {
+	up, err := m.changeUpload(id, func(up *upload.Upload) error {
+		if err := up.Transition(upload.Failed); err != nil {
+			return err
+		}
+
+		up.Reason, up.ServerReason = clientmsg, msg
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	m.store.CancelUpload(*up)
+	m.closeSession(up.ID)
+
+	e := events.New(events.PushFailed, up.Image)
+	e.UploadID, e.Uploader = up.ID, up.Uploader
+	e.Reason, e.ServerReason = clientmsg, msg
+	m.publish(e)
+
+	return up, nil
+}
+
+// SweepUploads fails the expired uploads and forgets the ones finished for
+// longer than the grace period.
+func (m *Mux) SweepUploads() error {
+	ups, err := m.backend.List()
+
+	if err != nil {
+		return err
+	}
+
+	for _, up := range ups {
+		if _, err := m.getUpload(up.ID); err != nil && err != upload.ErrNotFound {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *Mux) upload(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case "GET":
+		m.authenticated(m.getUploadDetails)(w, req)
+	case "DELETE":
+		m.audited("cancel", m.authenticated(m.deleteUpload))(w, req)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (m *Mux) getUploadDetails(w http.ResponseWriter, req *http.Request) {
+	num, err := strconv.ParseUint(mux.Vars(req)["num"], 10, 64)
+
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	up, err := m.getUpload(num)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if !m.owns(req, up) {
+		writeError(w, auth.ErrForbidden)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, m.newUploadDetails(up))
+}
+
+func (m *Mux) deleteUpload(w http.ResponseWriter, req *http.Request) {
+	num, err := strconv.ParseUint(mux.Vars(req)["num"], 10, 64)
+
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	d := auditing(req)
+	d.uploadID = num
+
+	up, err := m.getUpload(num)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	d.image, d.uploader = up.Image, up.Uploader
+
+	if !m.owns(req, up) {
+		writeError(w, auth.ErrForbidden)
+		return
+	}
+
+	if up.State == upload.Failed {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if _, err := m.cancelUpload(up.ID, "upload cancelled", ""); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (m *Mux) listUploads(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	ups, err := m.backend.List()
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	res := []uploadDetails{}
+
+	for _, up := range ups {
+		res = append(res, m.newUploadDetails(up))
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
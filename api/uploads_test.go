@@ -0,0 +1,113 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestUploadOwnership(t *testing.T) {
+	m, _, cleanup := newTestMux(t, Config{
+		Authenticator: tokens{"alice": true, "bob": true, "carol": true},
+	})
+	defer cleanup()
+
+	// Upload 1 is bob's, upload 2 anonymous.
+	do(m, "bob", "POST", "/example.com/app-1.0.0-linux-amd64.aci/startupload", "")
+	do(m, "", "POST", "/example.com/app-1.0.0-linux-amd64.aci/startupload", "")
+
+	for _, tt := range []struct {
+		principal, method, url string
+		code                   int
+	}{
+		{"", "GET", "/upload/1", http.StatusUnauthorized},
+		{"carol", "GET", "/upload/1", http.StatusForbidden},
+		{"bob", "GET", "/upload/1", http.StatusOK},
+		{"alice", "GET", "/upload/1", http.StatusOK},
+		{"", "DELETE", "/upload/1", http.StatusUnauthorized},
+		{"carol", "DELETE", "/upload/1", http.StatusForbidden},
+		{"bob", "DELETE", "/upload/1", http.StatusNoContent},
+		{"bob", "GET", "/upload/2", http.StatusForbidden},
+		{"bob", "DELETE", "/upload/2", http.StatusForbidden},
+		{"alice", "DELETE", "/upload/2", http.StatusNoContent},
+	} {
+		if w := do(m, tt.principal, tt.method, tt.url, ""); w.Code != tt.code {
+			t.Errorf("%s %s as %q: got %d, expected %d", tt.method, tt.url, tt.principal, w.Code, tt.code)
+		}
+	}
+}
+
+func TestNoAdmins(t *testing.T) {
+	m, _, cleanup := newTestMux(t, Config{Admins: []string{}})
+	defer cleanup()
+
+	do(m, "bob", "POST", "/example.com/app-1.0.0-linux-amd64.aci/startupload", "")
+
+	for _, tt := range []struct {
+		principal, method, url string
+		code                   int
+	}{
+		{"alice", "GET", "/upload/1", http.StatusForbidden},
+		{"alice", "DELETE", "/upload/1", http.StatusForbidden},
+		{"alice", "GET", "/api/v1/uploads", http.StatusForbidden},
+		{"bob", "GET", "/api/v1/uploads", http.StatusForbidden},
+		{"bob", "GET", "/upload/1", http.StatusOK},
+	} {
+		if w := do(m, tt.principal, tt.method, tt.url, ""); w.Code != tt.code {
+			t.Errorf("%s %s as %q: got %d, expected %d", tt.method, tt.url, tt.principal, w.Code, tt.code)
+		}
+	}
+}
+
+func TestCompleteRetried(t *testing.T) {
+	m, _, cleanup := newTestMux(t, Config{UploadGrace: time.Hour})
+	defer cleanup()
+
+	complete := func(id, body string) (int, completeMsg) {
+		w := do(m, "bob", "POST", "/complete/"+id, body)
+		msg := completeMsg{}
+		json.Unmarshal(w.Body.Bytes(), &msg)
+
+		return w.Code, msg
+	}
+
+	published := send(t, m, "bob", "example.com/app", "1.0.0", 3)
+	_, first := complete(published, `{"success": true}`)
+
+	if !first.Success || first.Digest == "" {
+		t.Fatalf("Upload failed: %+v", first)
+	}
+
+	failed := send(t, m, "bob", "example.com/app", "1.1.0", 3)
+	complete(failed, `{"success": false, "reason": "interrupted"}`)
+
+	// Retries get the original outcome, whatever they send.
+	for _, tt := range []struct {
+		id      string
+		success bool
+		reason  string
+	}{
+		{published, true, ""},
+		{failed, false, "interrupted"},
+	} {
+		for _, body := range []string{`{"success": true}`, `{"success": false}`, "garbage"} {
+			code, msg := complete(tt.id, body)
+
+			if code != http.StatusOK || msg.Success != tt.success || msg.Reason != tt.reason {
+				t.Errorf("%s %s: got %d %+v", tt.id, body, code, msg)
+			}
+
+			if tt.success && msg.Digest != first.Digest {
+				t.Errorf("%s: wrong digest %s", tt.id, msg.Digest)
+			}
+		}
+	}
+
+	// Forgotten after the grace period.
+	m.uploadGrace = 0
+
+	if code, _ := complete(published, `{"success": true}`); code != http.StatusNotFound {
+		t.Errorf("Upload remembered after the grace period: %d", code)
+	}
+}
@@ -0,0 +1,144 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrNotQueryable = errors.New("The audit log can't be queried")
+
+const (
+	Success = "success"
+	Failure = "failure"
+)
+
+// Record describes a mutating operation and its outcome.
+type Record struct {
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"`
+	Principal string    `json:"principal,omitempty"`
+	// Uploader is the principal who started the upload an operation is
+	// about.
+	Uploader string `json:"uploader,omitempty"`
+	Client   string `json:"client"`
+	Image    string `json:"image,omitempty"`
+	Version  string `json:"version,omitempty"`
+	Tag      string `json:"tag,omitempty"`
+	UploadID uint64 `json:"upload_id,omitempty"`
+	Digest   string `json:"digest,omitempty"`
+	Outcome  string `json:"outcome"`
+	Status   int    `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Filter selects records by image name prefix and principal, whether the
+// principal made the operation or started the upload it is about. Only the
+// most recent Limit ones are selected if it isn't 0.
+type Filter struct {
+	Image     string
+	Principal string
+	Limit     int
+}
+
+func (f *Filter) Match(r *Record) bool {
+	return strings.HasPrefix(r.Image, f.Image) &&
+		(f.Principal == "" || r.Principal == f.Principal || r.Uploader == f.Principal)
+}
+
+type Sink interface {
+	Write(*Record) error
+	// Query returns the matching records, oldest first, or
+	// ErrNotQueryable.
+	Query(Filter) ([]*Record, error)
+}
+
+// WriterSink writes the records as JSON lines, to stdout for instance. It
+// can't be queried.
+type WriterSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+func (s *WriterSink) Write(r *Record) error {
+	blob, err := json.Marshal(r)
+
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.w.Write(append(blob, '\n'))
+
+	return err
+}
+
+func (s *WriterSink) Query(Filter) ([]*Record, error) {
+	return nil, ErrNotQueryable
+}
+
+// FileSink appends the records as JSON lines to a local file, queries scan
+// the whole file.
+type FileSink struct {
+	*WriterSink
+
+	path string
+}
+
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileSink{NewWriterSink(f), path}, nil
+}
+
+func (s *FileSink) Query(filter Filter) ([]*Record, error) {
+	f, err := os.Open(s.path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	res := []*Record{}
+	dec := json.NewDecoder(f)
+
+	// The records are decoded whatever their length, a line per record.
+	for {
+		r := &Record{}
+
+		if err := dec.Decode(r); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if filter.Match(r) {
+			res = append(res, r)
+		}
+	}
+
+	return limit(res, filter.Limit), nil
+}
+
+func limit(records []*Record, n int) []*Record {
+	if n > 0 && len(records) > n {
+		return records[len(records)-n:]
+	}
+
+	return records
+}
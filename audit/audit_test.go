@@ -0,0 +1,117 @@
+package audit
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appc/acserver/storage/filesystem"
+)
+
+func testQuery(t *testing.T, s Sink) {
+	d := time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC)
+
+	for i, r := range []*Record{
+		{Action: "startupload", Principal: "ci", Image: "example.com/app-1.0.0-linux-amd64.aci"},
+		{Action: "complete", Uploader: "ci", Image: "example.com/app-1.0.0-linux-amd64.aci"},
+		{Action: "delete", Principal: "ops", Image: "example.com/app", Version: "0.9.0"},
+		{Action: "yank", Principal: "ci", Image: "example.com/other"},
+	} {
+		r.Time = d.Add(time.Duration(i) * time.Second)
+
+		if err := s.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		filter  Filter
+		actions []string
+	}{
+		{Filter{}, []string{"startupload", "complete", "delete", "yank"}},
+		{Filter{Image: "example.com/app"}, []string{"startupload", "complete", "delete"}},
+		{Filter{Principal: "ci"}, []string{"startupload", "complete", "yank"}},
+		{Filter{Principal: "ci", Limit: 2}, []string{"complete", "yank"}},
+		{Filter{Principal: "ops"}, []string{"delete"}},
+	} {
+		records, err := s.Query(tt.filter)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		actions := []string{}
+
+		for _, r := range records {
+			actions = append(actions, r.Action)
+		}
+
+		if len(actions) != len(tt.actions) {
+			t.Errorf("%+v: wrong records: %v", tt.filter, actions)
+			continue
+		}
+
+		for i := range actions {
+			if actions[i] != tt.actions[i] {
+				t.Errorf("%+v: wrong records: %v", tt.filter, actions)
+				break
+			}
+		}
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acserver-audit")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	s, err := NewFileSink(path.Join(dir, "audit.log"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testQuery(t, s)
+
+	// Records longer than a scanner's buffer are read too.
+	long := strings.Repeat("a", 1<<17)
+
+	if err := s.Write(&Record{Action: "delete", Image: long}); err != nil {
+		t.Fatal(err)
+	}
+
+	if records, err := s.Query(Filter{Image: long}); err != nil || len(records) != 1 {
+		t.Errorf("Long record not read: %d %v", len(records), err)
+	}
+}
+
+func TestStorageSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acserver-audit")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	store, err := filesystem.NewStorage(dir, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testQuery(t, NewStorageSink(store))
+}
+
+func TestWriterSink(t *testing.T) {
+	if _, err := NewWriterSink(ioutil.Discard).Query(Filter{}); err != ErrNotQueryable {
+		t.Errorf("Wrong error: %v", err)
+	}
+}
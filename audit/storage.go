@@ -0,0 +1,85 @@
+package audit
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/appc/acserver/storage"
+)
+
+const storagePrefix = "audit/"
+
+// StorageSink keeps every record in its own metadata document of the
+// storage, named after its time so that the replicas sharing the storage
+// never overwrite each other's records.
+type StorageSink struct {
+	store storage.MetadataStore
+}
+
+func NewStorageSink(store storage.MetadataStore) *StorageSink {
+	return &StorageSink{store}
+}
+
+func (s *StorageSink) Write(r *Record) error {
+	blob, err := json.Marshal(r)
+
+	if err != nil {
+		return err
+	}
+
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+
+	return s.store.PutMetadata(
+		fmt.Sprintf(
+			"%s%s/%s-%s.json",
+			storagePrefix,
+			r.Time.UTC().Format("2006-01-02"),
+			r.Time.UTC().Format("150405.000000000"),
+			hex.EncodeToString(suffix),
+		),
+		blob,
+	)
+}
+
+func (s *StorageSink) Query(filter Filter) ([]*Record, error) {
+	keys, err := s.store.ListMetadata(storagePrefix)
+
+	if err != nil {
+		return nil, err
+	}
+
+	res := []*Record{}
+
+	// The keys sort chronologically, read from the most recent ones so that
+	// a limited query stops early.
+	for i := len(keys) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && len(res) == filter.Limit {
+			break
+		}
+
+		blob, err := s.store.GetMetadata(keys[i])
+
+		if err != nil {
+			return nil, err
+		}
+
+		r := &Record{}
+
+		if err := json.Unmarshal(blob, r); err != nil {
+			return nil, err
+		}
+
+		if filter.Match(r) {
+			res = append(res, r)
+		}
+	}
+
+	for i, j := 0, len(res)-1; i < j; i, j = i+1, j-1 {
+		res[i], res[j] = res[j], res[i]
+	}
+
+	return res, nil
+}
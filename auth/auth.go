@@ -0,0 +1,15 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+)
+
+var (
+	ErrUnauthorized = errors.New("Unauthorized")
+	ErrForbidden    = errors.New("Forbidden")
+)
+
+type Authenticator interface {
+	Authenticate(*http.Request) (string, error)
+}
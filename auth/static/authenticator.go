@@ -0,0 +1,90 @@
+package static
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/appc/acserver/auth"
+)
+
+type credential struct {
+	principal string
+	token     string
+}
+
+type Authenticator struct {
+	credentials []credential
+}
+
+// NewAuthenticator reads a file holding one "principal token" pair per line,
+// blank lines and lines starting with # are ignored.
+func NewAuthenticator(path string) (*Authenticator, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	a := &Authenticator{}
+	scanner := bufio.NewScanner(f)
+
+	for i := 1; scanner.Scan(); i++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"principal token\"", path, i)
+		}
+
+		a.credentials = append(a.credentials, credential{fields[0], fields[1]})
+	}
+
+	return a, scanner.Err()
+}
+
+func (a *Authenticator) Authenticate(req *http.Request) (string, error) {
+	var token string
+
+	if user, password, ok := req.BasicAuth(); ok {
+		token = password
+
+		for _, c := range a.credentials {
+			if c.principal == user && equal(c.token, token) {
+				return c.principal, nil
+			}
+		}
+
+		return "", auth.ErrUnauthorized
+	}
+
+	header := req.Header.Get("Authorization")
+
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", auth.ErrUnauthorized
+	}
+
+	token = strings.TrimPrefix(header, "Bearer ")
+
+	for _, c := range a.credentials {
+		if equal(c.token, token) {
+			return c.principal, nil
+		}
+	}
+
+	return "", auth.ErrUnauthorized
+}
+
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,80 @@
+package etcd
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/appc/acserver/Godeps/_workspace/src/github.com/coreos/etcd/client"
+	"github.com/appc/acserver/Godeps/_workspace/src/golang.org/x/net/context"
+)
+
+// retryDelay is how long the watch waits before reconnecting after an error.
+const retryDelay = time.Second
+
+// Notifier bumps a key on every notification and watches it for the changes
+// made by the other replicas.
+type Notifier struct {
+	api client.KeysAPI
+
+	key    string
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewNotifier(endpoints []string, key string) (*Notifier, error) {
+	cfg := client.Config{
+		Endpoints: endpoints,
+		Transport: client.DefaultTransport,
+	}
+
+	c, err := client.New(cfg)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Notifier{client.NewKeysAPI(c), key, ctx, cancel}, nil
+}
+
+func (n *Notifier) Notify() error {
+	_, err := n.api.Set(
+		context.Background(),
+		n.key,
+		strconv.FormatInt(time.Now().UnixNano(), 10),
+		nil,
+	)
+
+	return err
+}
+
+func (n *Notifier) Watch(f func()) {
+	go func() {
+		w := n.api.Watcher(n.key, nil)
+
+		for {
+			_, err := w.Next(n.ctx)
+
+			if n.ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				// Notifications may have been missed while disconnected.
+				f()
+				time.Sleep(retryDelay)
+				w = n.api.Watcher(n.key, nil)
+				continue
+			}
+
+			f()
+		}
+	}()
+}
+
+func (n *Notifier) Close() error {
+	n.cancel()
+
+	return nil
+}
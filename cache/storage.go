@@ -0,0 +1,131 @@
+package cache
+
+import (
+	"sync"
+
+	"github.com/appc/acserver/aci"
+	"github.com/appc/acserver/storage"
+	"github.com/appc/acserver/upload"
+)
+
+// Notifier propagates invalidations between the replicas sharing a storage.
+type Notifier interface {
+	// Notify tells the other replicas their listings are stale.
+	Notify() error
+	// Watch calls the function every time a replica notifies, until Close.
+	Watch(func())
+	Close() error
+}
+
+// Storage keeps the result of ListACIs in memory until an image is
+// published, deleted, yanked or tagged, locally or on another replica.
+type Storage struct {
+	storage.Wrapper
+
+	notifier Notifier
+
+	mu         sync.Mutex
+	generation uint64
+	valid      bool
+	acis       []aci.Aci
+	invalid    []aci.InvalidFile
+}
+
+// NewStorage wraps the storage with a cache, the notifier is optional.
+func NewStorage(store storage.Storage, notifier Notifier) *Storage {
+	s := &Storage{Wrapper: storage.Wrapper{Storage: store}, notifier: notifier}
+
+	if notifier != nil {
+		notifier.Watch(s.Invalidate)
+	}
+
+	return s
+}
+
+func (s *Storage) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.generation++
+	s.valid = false
+	s.acis, s.invalid = nil, nil
+}
+
+func (s *Storage) ListACIs() ([]aci.Aci, []aci.InvalidFile, error) {
+	s.mu.Lock()
+
+	if s.valid {
+		defer s.mu.Unlock()
+		return copyAcis(s.acis), append([]aci.InvalidFile{}, s.invalid...), nil
+	}
+
+	generation := s.generation
+	s.mu.Unlock()
+
+	acis, invalid, err := s.Storage.ListACIs()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The listing may be stale already if an invalidation happened while it
+	// was being built.
+	if generation == s.generation {
+		s.valid = true
+		s.acis, s.invalid = copyAcis(acis), append([]aci.InvalidFile{}, invalid...)
+	}
+
+	return acis, invalid, nil
+}
+
+func (s *Storage) FinishUpload(up upload.Upload) error {
+	defer s.changed()
+
+	return s.Storage.FinishUpload(up)
+}
+
+func (s *Storage) DeleteACI(n string) error {
+	defer s.changed()
+
+	return s.Storage.DeleteACI(n)
+}
+
+func (s *Storage) YankACI(n string, yanked bool) error {
+	defer s.changed()
+
+	return s.Storage.YankACI(n, yanked)
+}
+
+func (s *Storage) TagACI(src, dst string) error {
+	defer s.changed()
+
+	return s.Storage.TagACI(src, dst)
+}
+
+// changed invalidates the cache even if the operation failed, it may have
+// been partially applied.
+func (s *Storage) changed() {
+	s.Invalidate()
+
+	if s.notifier != nil {
+		s.notifier.Notify()
+	}
+}
+
+// copyAcis protects the cached listing from the callers sorting or filtering
+// the details in place.
+func copyAcis(acis []aci.Aci) []aci.Aci {
+	res := make([]aci.Aci, len(acis))
+
+	for i, a := range acis {
+		res[i] = aci.Aci{
+			Name:    a.Name,
+			Details: append([]aci.AciDetails{}, a.Details...),
+		}
+	}
+
+	return res
+}
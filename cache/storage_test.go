@@ -0,0 +1,133 @@
+package cache_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/appc/acserver/cache"
+	"github.com/appc/acserver/storage"
+	"github.com/appc/acserver/storage/filesystem"
+	"github.com/appc/acserver/upload"
+)
+
+func newFilesystem(tb testing.TB, images int) (*filesystem.Storage, func()) {
+	dir, err := ioutil.TempDir("", "acserver-cache")
+
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	fs, err := filesystem.NewStorage(dir, nil)
+
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	for i := 0; i < images; i++ {
+		publish(tb, fs, uint64(i), fmt.Sprintf("example.com/app%d-1.0.%d-linux-amd64.aci", i%10, i))
+	}
+
+	return fs, func() { os.RemoveAll(dir) }
+}
+
+func publish(tb testing.TB, s storage.Storage, id uint64, name string) {
+	up := upload.Upload{ID: id, Image: name}
+
+	for _, err := range []error{
+		s.UploadACI(up, strings.NewReader("aci")),
+		s.UploadASC(up, strings.NewReader("asc")),
+		s.FinishUpload(up),
+	} {
+		if err != nil {
+			tb.Fatal(err)
+		}
+	}
+}
+
+func count(tb testing.TB, s storage.Storage) int {
+	acis, _, err := s.ListACIs()
+
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	n := 0
+
+	for _, a := range acis {
+		n += len(a.Details)
+	}
+
+	return n
+}
+
+func TestInvalidation(t *testing.T) {
+	fs, cleanup := newFilesystem(t, 2)
+	defer cleanup()
+
+	s := cache.NewStorage(fs, nil)
+
+	if n := count(t, s); n != 2 {
+		t.Fatalf("Wrong count: %d", n)
+	}
+
+	// Changes made behind the cache aren't seen.
+	publish(t, fs, 2, "example.com/app-1.0.2-linux-amd64.aci")
+
+	if n := count(t, s); n != 2 {
+		t.Fatalf("Wrong cached count: %d", n)
+	}
+
+	publish(t, s, 3, "example.com/app-1.0.3-linux-amd64.aci")
+
+	if n := count(t, s); n != 4 {
+		t.Fatalf("Wrong count after publish: %d", n)
+	}
+
+	if err := s.DeleteACI("example.com/app-1.0.3-linux-amd64.aci"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := count(t, s); n != 3 {
+		t.Fatalf("Wrong count after delete: %d", n)
+	}
+}
+
+func TestCachedListingIsCopied(t *testing.T) {
+	fs, cleanup := newFilesystem(t, 1)
+	defer cleanup()
+
+	s := cache.NewStorage(fs, nil)
+	acis, _, _ := s.ListACIs()
+	acis[0].Details[0].Version = "modified"
+
+	if acis, _, _ = s.ListACIs(); acis[0].Details[0].Version == "modified" {
+		t.Errorf("The cached listing was modified")
+	}
+}
+
+func benchmarkListACIs(b *testing.B, images int, cached bool) {
+	fs, cleanup := newFilesystem(b, images)
+	defer cleanup()
+
+	var s storage.Storage = fs
+
+	if cached {
+		s = cache.NewStorage(fs, nil)
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, _, err := s.ListACIs(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkListACIs10(b *testing.B)         { benchmarkListACIs(b, 10, false) }
+func BenchmarkListACIs1000(b *testing.B)       { benchmarkListACIs(b, 1000, false) }
+func BenchmarkCachedListACIs10(b *testing.B)   { benchmarkListACIs(b, 10, true) }
+func BenchmarkCachedListACIs1000(b *testing.B) { benchmarkListACIs(b, 1000, true) }
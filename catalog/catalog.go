@@ -0,0 +1,35 @@
+package catalog
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Entry describes a published image file. Entries whose file name couldn't
+// be parsed only carry the File and the Error.
+type Entry struct {
+	File     string          `json:"file"`
+	Name     string          `json:"name,omitempty"`
+	Version  string          `json:"version,omitempty"`
+	OS       string          `json:"os,omitempty"`
+	Arch     string          `json:"arch,omitempty"`
+	Size     int64           `json:"size"`
+	Digest   string          `json:"digest,omitempty"`
+	Signed   bool            `json:"signed"`
+	Signer   string          `json:"signer,omitempty"`
+	Uploader string          `json:"uploader,omitempty"`
+	Yanked   bool            `json:"yanked"`
+	Manifest json.RawMessage `json:"manifest,omitempty"`
+	Error    string          `json:"error,omitempty"`
+	Created  time.Time       `json:"created"`
+	Updated  time.Time       `json:"updated"`
+}
+
+// Catalog stores the entries by file name. Implementations return the
+// storage package errors so that they can be reported the same way.
+type Catalog interface {
+	Get(string) (*Entry, error)
+	Put(*Entry) error
+	Delete(string) error
+	List() ([]*Entry, error)
+}
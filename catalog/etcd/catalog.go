@@ -0,0 +1,121 @@
+package etcd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/appc/acserver/Godeps/_workspace/src/github.com/coreos/etcd/client"
+	"github.com/appc/acserver/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/appc/acserver/catalog"
+	"github.com/appc/acserver/storage"
+)
+
+type Catalog struct {
+	api client.KeysAPI
+
+	namespace string
+}
+
+func NewCatalog(endpoints []string, namespace string) (*Catalog, error) {
+	cfg := client.Config{
+		Endpoints: endpoints,
+		Transport: client.DefaultTransport,
+	}
+
+	c, err := client.New(cfg)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Catalog{client.NewKeysAPI(c), namespace}, nil
+}
+
+// key escapes the file names, their slashes would otherwise be interpreted
+// as etcd directories.
+func (c *Catalog) key(file string) string {
+	return fmt.Sprintf("%s/%s", c.namespace, url.QueryEscape(file))
+}
+
+func (c *Catalog) Get(file string) (*catalog.Entry, error) {
+	n, err := c.api.Get(context.Background(), c.key(file), nil)
+
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	e := catalog.Entry{}
+
+	if err := json.Unmarshal([]byte(n.Node.Value), &e); err != nil {
+		return nil, err
+	}
+
+	return &e, nil
+}
+
+func (c *Catalog) Put(e *catalog.Entry) error {
+	blob, err := json.Marshal(e)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = c.api.Set(context.Background(), c.key(e.File), string(blob), nil)
+
+	return translateError(err)
+}
+
+func (c *Catalog) Delete(file string) error {
+	_, err := c.api.Delete(context.Background(), c.key(file), nil)
+
+	return translateError(err)
+}
+
+func (c *Catalog) List() ([]*catalog.Entry, error) {
+	res := []*catalog.Entry{}
+
+	r, err := c.api.Get(context.Background(), c.namespace, nil)
+
+	if err := translateError(err); err == storage.ErrNotFound {
+		return res, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, n := range r.Node.Nodes {
+		e := catalog.Entry{}
+
+		if err := json.Unmarshal([]byte(n.Value), &e); err != nil {
+			return nil, err
+		}
+
+		res = append(res, &e)
+	}
+
+	return res, nil
+}
+
+func translateError(err error) error {
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case client.Error:
+		switch e.Code {
+		case client.ErrorCodeKeyNotFound:
+			return storage.ErrNotFound
+		case client.ErrorCodeNodeExist, client.ErrorCodeTestFailed:
+			return storage.ErrConflict
+		case client.ErrorCodeRaftInternal, client.ErrorCodeLeaderElect:
+			return storage.ErrUnavailable
+		}
+	case *client.ClusterError:
+		return storage.ErrUnavailable
+	}
+
+	if err == client.ErrNoEndpoints || err == context.DeadlineExceeded {
+		return storage.ErrUnavailable
+	}
+
+	return err
+}
@@ -0,0 +1,63 @@
+package memory
+
+import (
+	"sync"
+
+	"github.com/appc/acserver/catalog"
+	"github.com/appc/acserver/storage"
+)
+
+type Catalog struct {
+	mu      sync.Mutex
+	entries map[string]catalog.Entry
+}
+
+func NewCatalog() (*Catalog, error) {
+	return &Catalog{entries: make(map[string]catalog.Entry)}, nil
+}
+
+func (c *Catalog) Get(file string) (*catalog.Entry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[file]; ok {
+		return &e, nil
+	}
+
+	return nil, storage.ErrNotFound
+}
+
+func (c *Catalog) Put(e *catalog.Entry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[e.File] = *e
+
+	return nil
+}
+
+func (c *Catalog) Delete(file string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[file]; ok {
+		delete(c.entries, file)
+		return nil
+	}
+
+	return storage.ErrNotFound
+}
+
+func (c *Catalog) List() ([]*catalog.Entry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	res := make([]*catalog.Entry, 0, len(c.entries))
+
+	for _, e := range c.entries {
+		e := e
+		res = append(res, &e)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,301 @@
+package catalog
+
+import (
+	"io/ioutil"
+	"log"
+	"time"
+
+	"github.com/appc/acserver/aci"
+	"github.com/appc/acserver/storage"
+	"github.com/appc/acserver/upload"
+)
+
+// Storage serves the listings from a catalog kept up to date as images are
+// published, deleted or yanked, instead of scanning the underlying storage.
+type Storage struct {
+	storage.Wrapper
+
+	catalog Catalog
+}
+
+func NewStorage(store storage.Storage, c Catalog) *Storage {
+	return &Storage{storage.Wrapper{Storage: store}, c}
+}
+
+func (s *Storage) ListACIs() ([]aci.Aci, []aci.InvalidFile, error) {
+	entries, err := s.catalog.List()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		acis       = []aci.Aci{}
+		invalid    = []aci.InvalidFile{}
+		aciDetails = map[string][]aci.AciDetails{}
+	)
+
+	for _, e := range entries {
+		if e.Error != "" {
+			invalid = append(invalid, aci.InvalidFile{Name: e.File, Error: e.Error})
+			continue
+		}
+
+		aciDetails[e.Name] = append(
+			aciDetails[e.Name],
+			aci.AciDetails{
+				Version:      e.Version,
+				OS:           e.OS,
+				Arch:         e.Arch,
+				File:         e.File,
+				Signed:       e.Signed,
+				Manifest:     len(e.Manifest) > 0,
+				Yanked:       e.Yanked,
+				LastMod:      e.Updated.Format(time.RubyDate),
+				LastModified: e.Updated,
+				Size:         e.Size,
+				Digest:       e.Digest,
+				Signer:       e.Signer,
+				Uploader:     e.Uploader,
+			},
+		)
+	}
+
+	for name, details := range aciDetails {
+		acis = append(acis, aci.Aci{Name: name, Details: details})
+	}
+
+	aci.SortAcis(acis)
+
+	return acis, invalid, nil
+}
+
+// The catalog is updated once the storage was changed, its failures are
+// only logged: the operation did happen and reindexing repairs the catalog.
+func logFailure(op, n string, err error) error {
+	if err != nil {
+		log.Printf("catalog: %s %s: %v, reindex to repair the catalog", op, n, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) FinishUpload(up upload.Upload) error {
+	if err := s.Storage.FinishUpload(up); err != nil {
+		return err
+	}
+
+	return logFailure("publish", up.Image, s.indexUpload(up))
+}
+
+func (s *Storage) indexUpload(up upload.Upload) error {
+	e, err := s.describe(up.Image)
+
+	if err != nil {
+		return err
+	}
+
+	e.Uploader = up.Uploader
+
+	if prev, err := s.catalog.Get(up.Image); err == nil {
+		e.Created = prev.Created
+	} else if err != storage.ErrNotFound {
+		return err
+	}
+
+	return s.catalog.Put(e)
+}
+
+func (s *Storage) DeleteACI(n string) error {
+	if err := s.Storage.DeleteACI(n); err != nil {
+		return err
+	}
+
+	if err := s.catalog.Delete(n); err != storage.ErrNotFound {
+		return logFailure("delete", n, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) YankACI(n string, yanked bool) error {
+	if err := s.Storage.YankACI(n, yanked); err != nil {
+		return err
+	}
+
+	return logFailure("yank", n, s.indexYank(n, yanked))
+}
+
+func (s *Storage) indexYank(n string, yanked bool) error {
+	e, err := s.catalog.Get(n)
+
+	if err == storage.ErrNotFound {
+		if e, err = s.describe(n); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	e.Yanked = yanked
+
+	return s.catalog.Put(e)
+}
+
+func (s *Storage) TagACI(src, dst string) error {
+	if err := s.Storage.TagACI(src, dst); err != nil {
+		return err
+	}
+
+	return logFailure("tag", dst, s.indexTag(src, dst))
+}
+
+func (s *Storage) indexTag(src, dst string) error {
+	e, err := s.describe(dst)
+
+	if err != nil {
+		return err
+	}
+
+	if prev, err := s.catalog.Get(src); err == nil {
+		e.Uploader = prev.Uploader
+	} else if err != storage.ErrNotFound {
+		return err
+	}
+
+	return s.catalog.Put(e)
+}
+
+// Reindex rebuilds the catalog from the content of the underlying storage,
+// keeping the uploaders and creation times already known. It returns the
+// number of files indexed.
+func (s *Storage) Reindex() (int, error) {
+	acis, invalid, err := s.Storage.ListACIs()
+
+	if err != nil {
+		return 0, err
+	}
+
+	entries, err := s.catalog.List()
+
+	if err != nil {
+		return 0, err
+	}
+
+	prev := map[string]*Entry{}
+
+	for _, e := range entries {
+		prev[e.File] = e
+	}
+
+	seen := map[string]bool{}
+
+	for _, a := range acis {
+		for _, d := range a.Details {
+			e, err := s.describe(d.File)
+
+			if err != nil {
+				return 0, err
+			}
+
+			e.Yanked = d.Yanked
+
+			if p, ok := prev[d.File]; ok {
+				e.Uploader = p.Uploader
+				e.Created = p.Created
+			}
+
+			if err := s.catalog.Put(e); err != nil {
+				return 0, err
+			}
+
+			seen[d.File] = true
+		}
+	}
+
+	for _, f := range invalid {
+		if err := s.catalog.Put(
+			&Entry{File: f.Name, Error: f.Error},
+		); err != nil {
+			return 0, err
+		}
+
+		seen[f.Name] = true
+	}
+
+	for file := range prev {
+		if seen[file] {
+			continue
+		}
+
+		if err := s.catalog.Delete(file); err != nil && err != storage.ErrNotFound {
+			return 0, err
+		}
+	}
+
+	return len(seen), nil
+}
+
+// describe builds the entry of a published file from its metadata, manifest
+// and signature.
+func (s *Storage) describe(file string) (*Entry, error) {
+	info, err := s.Storage.StatACI(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Entry{
+		File:    file,
+		Size:    info.Size,
+		Digest:  info.Digest,
+		Created: info.ModTime,
+		Updated: info.ModTime,
+	}
+
+	var m *aci.Manifest
+
+	blob, err := s.readSidecar(file + aci.ManifestExt)
+
+	if err != nil {
+		return nil, err
+	} else if blob != nil {
+		if m, err = aci.ParseManifest(blob); err == nil {
+			e.Manifest = blob
+		}
+	}
+
+	if blob, err = s.readSidecar(file + aci.SignatureExt); err != nil {
+		return nil, err
+	} else if blob != nil {
+		e.Signed = true
+		e.Signer, _ = aci.SignatureKeyID(blob)
+	}
+
+	img, err := aci.ParseFilenameWithManifest(file, m)
+
+	if err != nil {
+		e.Error = err.Error()
+		return e, nil
+	}
+
+	e.Name, e.Version, e.OS, e.Arch = img.Name, img.Version, img.OS, img.Arch
+
+	return e, nil
+}
+
+// readSidecar returns the content of a file stored next to an image, or nil
+// if there is none.
+func (s *Storage) readSidecar(n string) ([]byte, error) {
+	r, err := s.Storage.DownloadACI(n)
+
+	if err == storage.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
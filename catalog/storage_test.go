@@ -0,0 +1,188 @@
+package catalog_test
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/appc/acserver/catalog"
+	"github.com/appc/acserver/catalog/memory"
+	"github.com/appc/acserver/storage"
+	"github.com/appc/acserver/storage/filesystem"
+	"github.com/appc/acserver/upload"
+)
+
+const manifest = `{"acKind": "ImageManifest", "acVersion": "0.7.0", "name": "example.com/my-app"}`
+
+func newTestStorage(t *testing.T) (*catalog.Storage, *filesystem.Storage, func()) {
+	dir, err := ioutil.TempDir("", "acserver-catalog")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err := filesystem.NewStorage(dir, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, _ := memory.NewCatalog()
+
+	return catalog.NewStorage(fs, c), fs, func() { os.RemoveAll(dir) }
+}
+
+func publish(t *testing.T, s storage.Storage, id uint64, name string) {
+	up := upload.Upload{ID: id, Image: name, Uploader: "ci", Digest: "sha512-" + name}
+
+	for _, err := range []error{
+		s.UploadACI(up, strings.NewReader("aci")),
+		s.UploadASC(up, strings.NewReader("asc")),
+		s.UploadManifest(up, strings.NewReader(manifest)),
+		s.FinishUpload(up),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCatalogTracksPublishedImages(t *testing.T) {
+	s, _, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	publish(t, s, 1, "example.com/my-app-1.0.0-linux-amd64.aci")
+	publish(t, s, 2, "example.com/my-app-1.1.0-linux-amd64.aci")
+
+	if err := s.DeleteACI("example.com/my-app-1.0.0-linux-amd64.aci"); err != nil {
+		t.Fatal(err)
+	}
+
+	acis, invalid, err := s.ListACIs()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(acis) != 1 || len(invalid) != 0 {
+		t.Fatalf("Wrong listing: %+v %+v", acis, invalid)
+	}
+
+	if a := acis[0]; a.Name != "example.com/my-app" || len(a.Details) != 1 {
+		t.Fatalf("Wrong image: %+v", a)
+	}
+
+	d := acis[0].Details[0]
+
+	if d.Version != "1.1.0" || d.Uploader != "ci" || !d.Signed || !strings.HasPrefix(d.Digest, "sha512-") {
+		t.Errorf("Wrong details: %+v", d)
+	}
+}
+
+func TestReindex(t *testing.T) {
+	s, fs, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	publish(t, s, 1, "example.com/my-app-1.0.0-linux-amd64.aci")
+
+	// Changed behind the catalog.
+	publish(t, fs, 2, "example.com/my-app-2.0.0-linux-amd64.aci")
+
+	if err := fs.YankACI("example.com/my-app-1.0.0-linux-amd64.aci", true); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := s.Reindex(); err != nil || n != 2 {
+		t.Fatalf("Wrong reindex: %d %v", n, err)
+	}
+
+	acis, _, err := s.ListACIs()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(acis) != 1 || len(acis[0].Details) != 2 {
+		t.Fatalf("Wrong listing: %+v", acis)
+	}
+
+	for _, d := range acis[0].Details {
+		switch d.Version {
+		case "1.0.0":
+			if d.Uploader != "ci" || !d.Yanked {
+				t.Errorf("Wrong details: %+v", d)
+			}
+		case "2.0.0":
+			if d.Uploader != "" || d.Yanked {
+				t.Errorf("Wrong details: %+v", d)
+			}
+		default:
+			t.Errorf("Unexpected version: %+v", d)
+		}
+	}
+}
+
+// failingCatalog fails every update, as an unreachable etcd.
+type failingCatalog struct {
+	catalog.Catalog
+}
+
+func (c failingCatalog) Put(*catalog.Entry) error {
+	return storage.ErrUnavailable
+}
+
+func (c failingCatalog) Delete(string) error {
+	return storage.ErrUnavailable
+}
+
+func TestCatalogFailuresKeepChanges(t *testing.T) {
+	_, fs, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	c, _ := memory.NewCatalog()
+	s := catalog.NewStorage(fs, failingCatalog{c})
+	name := "example.com/my-app-1.0.0-linux-amd64.aci"
+
+	// The image is published even though it isn't cataloged.
+	publish(t, s, 1, name)
+
+	if _, err := fs.StatACI(name); err != nil {
+		t.Errorf("Image not published: %v", err)
+	}
+
+	for _, err := range []error{
+		s.YankACI(name, true),
+		s.TagACI(name, "example.com/my-app-stable-linux-amd64.aci"),
+		s.DeleteACI(name),
+	} {
+		if err != nil {
+			t.Errorf("Catalog failure reported: %v", err)
+		}
+	}
+
+	if _, err := fs.StatACI(name); err != storage.ErrNotFound {
+		t.Errorf("Image not deleted: %v", err)
+	}
+}
+
+func TestMetadataForwarded(t *testing.T) {
+	s, fs, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	if err := s.PutMetadata("tags/example.com/my-app/stable.json", []byte("[]")); err != nil {
+		t.Fatal(err)
+	}
+
+	if blob, err := fs.GetMetadata("tags/example.com/my-app/stable.json"); err != nil || string(blob) != "[]" {
+		t.Errorf("Metadata not forwarded: %q %v", blob, err)
+	}
+
+	// Only the core interface is implemented.
+	c, _ := memory.NewCatalog()
+	bare := catalog.NewStorage(struct{ storage.Storage }{fs}, c)
+
+	if _, err := bare.GetMetadata("tags/example.com/my-app/stable.json"); err != storage.ErrNotSupported {
+		t.Errorf("Wrong error: %v", err)
+	}
+}
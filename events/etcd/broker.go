@@ -0,0 +1,189 @@
+package etcd
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/appc/acserver/Godeps/_workspace/src/github.com/coreos/etcd/client"
+	"github.com/appc/acserver/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/appc/acserver/events"
+)
+
+const (
+	// publishBuffer is the number of events waiting to be written to etcd
+	// beyond which new ones are dropped.
+	publishBuffer = 1024
+	retryDelay    = time.Second
+)
+
+// Broker shares the events between the replicas using an etcd directory of
+// in-order keys expiring after a while. The events are numbered with their
+// etcd index.
+type Broker struct {
+	api client.KeysAPI
+
+	dir     string
+	ttl     time.Duration
+	hub     *events.Hub
+	publish chan *events.Event
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// NewBroker returns a broker keeping the events in etcd for the given
+// duration, and the given number of them in memory for the subscribers
+// resuming.
+func NewBroker(endpoints []string, dir string, ttl time.Duration, history int) (*Broker, error) {
+	cfg := client.Config{
+		Endpoints: endpoints,
+		Transport: client.DefaultTransport,
+	}
+
+	c, err := client.New(cfg)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &Broker{
+		api:     client.NewKeysAPI(c),
+		dir:     dir,
+		ttl:     ttl,
+		hub:     events.NewHub(history),
+		publish: make(chan *events.Event, publishBuffer),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+
+	index, err := b.load()
+
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	go b.write()
+	go b.watch(index)
+
+	return b, nil
+}
+
+func (b *Broker) Publish(e *events.Event) {
+	select {
+	case b.publish <- e:
+	default:
+		log.Printf("events: queue full, %s event for %s dropped", e.Type, e.File)
+	}
+}
+
+func (b *Broker) Subscribe(after uint64) (*events.Subscription, error) {
+	return b.hub.Subscribe(after), nil
+}
+
+func (b *Broker) Close() {
+	b.cancel()
+}
+
+// load dispatches the events still in etcd and returns the index to watch
+// from.
+func (b *Broker) load() (uint64, error) {
+	r, err := b.api.Get(b.ctx, b.dir, &client.GetOptions{Sort: true})
+
+	if e, ok := err.(client.Error); ok && e.Code == client.ErrorCodeKeyNotFound {
+		return e.Index, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	nodes := r.Node.Nodes
+	sort.Sort(byIndex(nodes))
+
+	for _, n := range nodes {
+		b.dispatch(n)
+	}
+
+	return r.Index, nil
+}
+
+func (b *Broker) write() {
+	for {
+		select {
+		case e := <-b.publish:
+			blob, err := json.Marshal(e)
+
+			if err != nil {
+				continue
+			}
+
+			if _, err := b.api.CreateInOrder(
+				b.ctx,
+				b.dir,
+				string(blob),
+				&client.CreateInOrderOptions{TTL: b.ttl},
+			); err != nil {
+				log.Printf("events: %s event for %s lost: %v", e.Type, e.File, err)
+			}
+		case <-b.ctx.Done():
+			return
+		}
+	}
+}
+
+func (b *Broker) watch(index uint64) {
+	w := b.api.Watcher(
+		b.dir,
+		&client.WatcherOptions{AfterIndex: index, Recursive: true},
+	)
+
+	for {
+		r, err := w.Next(b.ctx)
+
+		if b.ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			// The events missed, if any, can't be recovered: start again
+			// from the current index.
+			log.Printf("events: watch failed: %v", err)
+			time.Sleep(retryDelay)
+
+			if e, ok := err.(client.Error); ok {
+				index = e.Index
+			}
+
+			w = b.api.Watcher(
+				b.dir,
+				&client.WatcherOptions{AfterIndex: index, Recursive: true},
+			)
+
+			continue
+		}
+
+		index = r.Node.ModifiedIndex
+
+		if r.Action == "create" {
+			b.dispatch(r.Node)
+		}
+	}
+}
+
+func (b *Broker) dispatch(n *client.Node) {
+	e := &events.Event{}
+
+	if err := json.Unmarshal([]byte(n.Value), e); err != nil {
+		return
+	}
+
+	e.ID = n.ModifiedIndex
+	b.hub.Dispatch(e)
+}
+
+type byIndex client.Nodes
+
+func (s byIndex) Len() int           { return len(s) }
+func (s byIndex) Less(i, j int) bool { return s[i].ModifiedIndex < s[j].ModifiedIndex }
+func (s byIndex) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
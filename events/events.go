@@ -0,0 +1,66 @@
+package events
+
+import (
+	"time"
+
+	"github.com/appc/acserver/aci"
+)
+
+type Type string
+
+const (
+	UploadStarted Type = "upload.started"
+	PartReceived  Type = "upload.part_received"
+	PushCompleted Type = "push.completed"
+	PushFailed    Type = "push.failed"
+	ImageDeleted  Type = "image.deleted"
+	ImageTagged   Type = "image.tagged"
+)
+
+// Event describes something that happened to an image of the repository.
+type Event struct {
+	// ID is assigned by the brokers, in increasing order.
+	ID           uint64    `json:"id,omitempty"`
+	Type         Type      `json:"type"`
+	Time         time.Time `json:"time"`
+	File         string    `json:"file"`
+	Name         string    `json:"name,omitempty"`
+	Version      string    `json:"version,omitempty"`
+	OS           string    `json:"os,omitempty"`
+	Arch         string    `json:"arch,omitempty"`
+	Digest       string    `json:"digest,omitempty"`
+	UploadID     uint64    `json:"upload_id,omitempty"`
+	Part         string    `json:"part,omitempty"`
+	Tag          string    `json:"tag,omitempty"`
+	Uploader     string    `json:"uploader,omitempty"`
+	Principal    string    `json:"principal,omitempty"`
+	Reason       string    `json:"reason,omitempty"`
+	ServerReason string    `json:"server_reason,omitempty"`
+}
+
+// Sink receives the events as they happen. Publish must not block, slow
+// consumers are expected to queue the events.
+type Sink interface {
+	Publish(*Event)
+}
+
+// Sinks publishes the events to several sinks.
+type Sinks []Sink
+
+func (s Sinks) Publish(e *Event) {
+	for _, sink := range s {
+		sink.Publish(e)
+	}
+}
+
+// New builds an event about an image file, filling in the name, version and
+// platform when the file name can be parsed.
+func New(t Type, file string) *Event {
+	e := &Event{Type: t, Time: time.Now(), File: file}
+
+	if img, err := aci.ParseFilename(file); err == nil {
+		e.Name, e.Version, e.OS, e.Arch = img.Name, img.Version, img.OS, img.Arch
+	}
+
+	return e
+}
@@ -0,0 +1,95 @@
+package events
+
+import "sync"
+
+// subscriptionBuffer is the number of events a subscriber may lag behind
+// before being dropped.
+const subscriptionBuffer = 256
+
+// Broker is a sink clients can follow, resuming after the last event they
+// received.
+type Broker interface {
+	Sink
+	Subscribe(after uint64) (*Subscription, error)
+}
+
+type Subscription struct {
+	// Backlog holds the recent events published after the requested ID.
+	Backlog []*Event
+	// C receives the following events. It is closed if the subscriber
+	// falls too far behind, it should then subscribe again.
+	C <-chan *Event
+
+	hub *Hub
+	c   chan *Event
+}
+
+func (s *Subscription) Close() {
+	s.hub.unsubscribe(s)
+}
+
+// Hub fans the events out to the local subscribers and keeps the most recent
+// ones so that they can resume. The events must be dispatched with increasing
+// IDs.
+type Hub struct {
+	mu      sync.Mutex
+	history []*Event
+	size    int
+	subs    map[*Subscription]struct{}
+}
+
+func NewHub(size int) *Hub {
+	return &Hub{size: size, subs: make(map[*Subscription]struct{})}
+}
+
+func (h *Hub) Dispatch(e *Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.history = append(h.history, e)
+
+	if len(h.history) > h.size {
+		h.history = h.history[len(h.history)-h.size:]
+	}
+
+	for s := range h.subs {
+		select {
+		case s.c <- e:
+		default:
+			delete(h.subs, s)
+			close(s.c)
+		}
+	}
+}
+
+// Subscribe returns a subscription to the events dispatched from now on,
+// with the backlog of the known ones following the given ID if it isn't 0.
+func (h *Hub) Subscribe(after uint64) *Subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	c := make(chan *Event, subscriptionBuffer)
+	s := &Subscription{C: c, hub: h, c: c}
+
+	if after > 0 {
+		for _, e := range h.history {
+			if e.ID > after {
+				s.Backlog = append(s.Backlog, e)
+			}
+		}
+	}
+
+	h.subs[s] = struct{}{}
+
+	return s
+}
+
+func (h *Hub) unsubscribe(s *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subs[s]; ok {
+		delete(h.subs, s)
+		close(s.c)
+	}
+}
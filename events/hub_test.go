@@ -0,0 +1,62 @@
+package events
+
+import "testing"
+
+func TestSubscribeBacklog(t *testing.T) {
+	h := NewHub(3)
+
+	for i := uint64(1); i <= 5; i++ {
+		h.Dispatch(&Event{ID: i})
+	}
+
+	for _, tt := range []struct {
+		after   uint64
+		backlog []uint64
+	}{
+		{0, nil},
+		{1, []uint64{3, 4, 5}},
+		{3, []uint64{4, 5}},
+		{5, nil},
+	} {
+		s := h.Subscribe(tt.after)
+		ids := []uint64{}
+
+		for _, e := range s.Backlog {
+			ids = append(ids, e.ID)
+		}
+
+		if len(ids) != len(tt.backlog) {
+			t.Errorf("%d: wrong backlog: %v", tt.after, ids)
+		}
+
+		for i := range ids {
+			if ids[i] != tt.backlog[i] {
+				t.Errorf("%d: wrong backlog: %v", tt.after, ids)
+			}
+		}
+
+		s.Close()
+	}
+}
+
+func TestSlowSubscriberIsDropped(t *testing.T) {
+	h := NewHub(1)
+	s := h.Subscribe(0)
+
+	for i := uint64(1); i <= subscriptionBuffer+1; i++ {
+		h.Dispatch(&Event{ID: i})
+	}
+
+	n := 0
+
+	for range s.C {
+		n++
+	}
+
+	if n != subscriptionBuffer {
+		t.Errorf("Wrong number of events received: %d", n)
+	}
+
+	// Closing a dropped subscription is harmless.
+	s.Close()
+}
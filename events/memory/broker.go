@@ -0,0 +1,36 @@
+package memory
+
+import (
+	"sync"
+
+	"github.com/appc/acserver/events"
+)
+
+// Broker numbers and dispatches the events of a single server.
+type Broker struct {
+	mu      sync.Mutex
+	counter uint64
+	hub     *events.Hub
+}
+
+// NewBroker returns a broker keeping the given number of events for the
+// subscribers resuming.
+func NewBroker(history int) (*Broker, error) {
+	return &Broker{hub: events.NewHub(history)}, nil
+}
+
+func (b *Broker) Publish(e *events.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.counter++
+
+	ev := *e
+	ev.ID = b.counter
+
+	b.hub.Dispatch(&ev)
+}
+
+func (b *Broker) Subscribe(after uint64) (*events.Subscription, error) {
+	return b.hub.Subscribe(after), nil
+}
@@ -0,0 +1,306 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/appc/acserver/events"
+)
+
+const (
+	EventHeader     = "X-Acserver-Event"
+	DeliveryHeader  = "X-Acserver-Delivery"
+	SignatureHeader = "X-Acserver-Signature"
+)
+
+// Hook is a receiver of the events, the ones it subscribes to or all of them
+// if it doesn't list any.
+type Hook struct {
+	URL    string        `json:"url"`
+	Secret string        `json:"secret"`
+	Events []events.Type `json:"events,omitempty"`
+}
+
+func (h *Hook) subscribes(t events.Type) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+
+	for _, s := range h.Events {
+		if s == t {
+			return true
+		}
+	}
+
+	return false
+}
+
+// LoadHooks reads a JSON array of hooks.
+func LoadHooks(path string) ([]Hook, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	hooks := []Hook{}
+
+	if err := json.NewDecoder(f).Decode(&hooks); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return hooks, nil
+}
+
+type Options struct {
+	// Workers is the number of deliveries made concurrently.
+	Workers int
+	// QueueSize is the number of deliveries waiting for a worker beyond
+	// which new ones are dropped.
+	QueueSize int
+	// MaxAttempts is the number of times a delivery is tried.
+	MaxAttempts int
+	// Backoff is the delay before the first retry, doubled for each of the
+	// following ones.
+	Backoff time.Duration
+	// Timeout bounds every attempt.
+	Timeout time.Duration
+}
+
+// Delivery is the record of an attempt written to the delivery log.
+type Delivery struct {
+	ID       string      `json:"id"`
+	URL      string      `json:"url"`
+	Event    events.Type `json:"event"`
+	File     string      `json:"file"`
+	Attempt  int         `json:"attempt"`
+	Status   int         `json:"status,omitempty"`
+	Error    string      `json:"error,omitempty"`
+	Time     time.Time   `json:"time"`
+	Duration string      `json:"duration"`
+}
+
+type delivery struct {
+	id      string
+	hook    *Hook
+	event   *events.Event
+	body    []byte
+	attempt int
+}
+
+// Dispatcher posts the events to the hooks from background workers, so that
+// slow receivers never delay the requests publishing them.
+type Dispatcher struct {
+	hooks  []Hook
+	opts   Options
+	client *http.Client
+
+	queue chan *delivery
+	done  chan struct{}
+	// pending counts the deliveries neither succeeded nor given up yet.
+	pending sync.WaitGroup
+
+	logMu sync.Mutex
+	log   io.Writer
+}
+
+// NewDispatcher starts the workers delivering to the hooks, every attempt is
+// written as a JSON line to the log.
+func NewDispatcher(hooks []Hook, log io.Writer, opts Options) *Dispatcher {
+	if opts.Workers <= 0 {
+		opts.Workers = 4
+	}
+
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 1024
+	}
+
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+
+	if opts.Backoff <= 0 {
+		opts.Backoff = time.Second
+	}
+
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+
+	d := &Dispatcher{
+		hooks:  hooks,
+		opts:   opts,
+		client: &http.Client{Timeout: opts.Timeout},
+		queue:  make(chan *delivery, opts.QueueSize),
+		done:   make(chan struct{}),
+		log:    log,
+	}
+
+	for i := 0; i < opts.Workers; i++ {
+		go d.work()
+	}
+
+	return d
+}
+
+func (d *Dispatcher) Publish(e *events.Event) {
+	body, err := json.Marshal(e)
+
+	if err != nil {
+		return
+	}
+
+	for i := range d.hooks {
+		if !d.hooks[i].subscribes(e.Type) {
+			continue
+		}
+
+		d.pending.Add(1)
+		d.enqueue(
+			&delivery{
+				id:      newID(),
+				hook:    &d.hooks[i],
+				event:   e,
+				body:    body,
+				attempt: 1,
+			},
+		)
+	}
+}
+
+// Close stops the workers, the pending deliveries are abandoned.
+func (d *Dispatcher) Close() {
+	close(d.done)
+}
+
+// Wait returns once the deliveries of the events published so far succeeded
+// or were given up, for the commands exiting after publishing. It must not
+// be called after Close.
+func (d *Dispatcher) Wait() {
+	d.pending.Wait()
+}
+
+func (d *Dispatcher) enqueue(dl *delivery) {
+	select {
+	case d.queue <- dl:
+	case <-d.done:
+		d.pending.Done()
+	default:
+		d.record(dl, 0, fmt.Errorf("queue full, delivery dropped"), 0)
+		d.pending.Done()
+	}
+}
+
+func (d *Dispatcher) work() {
+	for {
+		select {
+		case dl := <-d.queue:
+			d.deliver(dl)
+		case <-d.done:
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(dl *delivery) {
+	t0 := time.Now()
+	status, err := d.post(dl)
+	d.record(dl, status, err, time.Since(t0))
+
+	if err == nil || dl.attempt >= d.opts.MaxAttempts {
+		d.pending.Done()
+		return
+	}
+
+	backoff := d.opts.Backoff << uint(dl.attempt-1)
+	dl.attempt++
+
+	time.AfterFunc(backoff, func() { d.enqueue(dl) })
+}
+
+func (d *Dispatcher) post(dl *delivery) (int, error) {
+	req, err := http.NewRequest("POST", dl.hook.URL, bytes.NewReader(dl.body))
+
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, string(dl.event.Type))
+	req.Header.Set(DeliveryHeader, dl.id)
+	req.Header.Set(SignatureHeader, Sign(dl.hook.Secret, dl.body))
+
+	resp, err := d.client.Do(req)
+
+	if err != nil {
+		return 0, err
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
+
+func (d *Dispatcher) record(dl *delivery, status int, err error, duration time.Duration) {
+	if d.log == nil {
+		return
+	}
+
+	r := Delivery{
+		ID:       dl.id,
+		URL:      dl.hook.URL,
+		Event:    dl.event.Type,
+		File:     dl.event.File,
+		Attempt:  dl.attempt,
+		Status:   status,
+		Time:     time.Now(),
+		Duration: duration.String(),
+	}
+
+	if err != nil {
+		r.Error = err.Error()
+	}
+
+	blob, err := json.Marshal(r)
+
+	if err != nil {
+		return
+	}
+
+	d.logMu.Lock()
+	defer d.logMu.Unlock()
+
+	d.log.Write(append(blob, '\n'))
+}
+
+// Sign returns the value of the signature header of a payload, its
+// HMAC-SHA256 keyed with the secret of the hook.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,97 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/appc/acserver/events"
+)
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) deliveries() []Delivery {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	res := []Delivery{}
+	dec := json.NewDecoder(bytes.NewReader(b.buf.Bytes()))
+
+	for dec.More() {
+		d := Delivery{}
+		dec.Decode(&d)
+		res = append(res, d)
+	}
+
+	return res
+}
+
+func TestDeliveryIsSignedAndRetried(t *testing.T) {
+	received := make(chan *events.Event, 1)
+	calls := 0
+
+	srv := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			body, _ := ioutil.ReadAll(req.Body)
+
+			if req.Header.Get(SignatureHeader) != Sign("secret", body) {
+				t.Errorf("Wrong signature: %s", req.Header.Get(SignatureHeader))
+			}
+
+			if calls++; calls == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+
+			e := &events.Event{}
+			json.Unmarshal(body, e)
+			received <- e
+		}),
+	)
+	defer srv.Close()
+
+	log := &syncBuffer{}
+	d := NewDispatcher(
+		[]Hook{
+			Hook{URL: srv.URL, Secret: "secret"},
+			Hook{URL: srv.URL, Events: []events.Type{events.ImageDeleted}},
+		},
+		log,
+		Options{Workers: 1, Backoff: time.Millisecond},
+	)
+	defer d.Close()
+
+	d.Publish(events.New(events.PushCompleted, "example.com/app-1.0.0-linux-amd64.aci"))
+
+	select {
+	case e := <-received:
+		if e.Type != events.PushCompleted || e.Name != "example.com/app" || e.Version != "1.0.0" {
+			t.Errorf("Wrong event: %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The event wasn't delivered")
+	}
+
+	// The successful attempt is logged once the response is read.
+	d.Wait()
+	deliveries := log.deliveries()
+
+	if len(deliveries) != 2 || deliveries[0].Status != 503 || deliveries[1].Attempt != 2 || deliveries[1].Error != "" {
+		t.Errorf("Wrong deliveries: %+v", deliveries)
+	}
+}
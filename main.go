@@ -17,10 +17,29 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/appc/acserver/api"
+	"github.com/appc/acserver/audit"
+	"github.com/appc/acserver/auth"
+	"github.com/appc/acserver/auth/static"
+	"github.com/appc/acserver/cache"
+	cacheetcd "github.com/appc/acserver/cache/etcd"
+	"github.com/appc/acserver/catalog"
+	catalogetcd "github.com/appc/acserver/catalog/etcd"
+	"github.com/appc/acserver/catalog/memory"
+	"github.com/appc/acserver/events"
+	eventsetcd "github.com/appc/acserver/events/etcd"
+	eventsmemory "github.com/appc/acserver/events/memory"
+	"github.com/appc/acserver/events/webhook"
+	"github.com/appc/acserver/quota"
+	"github.com/appc/acserver/ratelimit"
+	"github.com/appc/acserver/retention"
 	"github.com/appc/acserver/storage"
 	"github.com/appc/acserver/storage/s3"
 	"github.com/appc/acserver/upload"
@@ -30,24 +49,103 @@ import (
 	"github.com/appc/acserver/Godeps/_workspace/src/github.com/upfluence/goamz/aws"
 )
 
+var etcdEndpoints = []string{"http://127.0.0.1:2379"}
+
+// eventHistory is the number of events kept in memory for the clients
+// resuming a stream.
+const eventHistory = 1000
+
+// uploadSweepInterval is how often expired and finished uploads are cleaned
+// up.
+const uploadSweepInterval = time.Minute
+
 var (
-	serverName  string
-	directory   string
-	templateDir string
-	store       storage.Storage
-	backend     upload.Backend
+	serverName    string
+	directory     string
+	templateDir   string
+	store         storage.Storage
+	backend       upload.Backend
+	authenticator auth.Authenticator
 
 	gpgpubkey = flag.String("pubkeys", "",
 		"Path to gpg public keys images will be signed with")
 	https = flag.Bool("https", false,
 		"Whether or not to provide https URLs for meta discovery")
-	port = flag.Int("port", 3000, "The port to run the server on")
+	port   = flag.Int("port", 3000, "The port to run the server on")
+	tokens = flag.String("tokens", "",
+		"Path to a file of \"principal token\" pairs allowed to manage images")
+	s3PartSize = flag.Int64("s3-part-size", s3.DefaultPartSize,
+		"Size of the parts uploads are streamed to S3 with, at least 5MB")
+	s3BufferDir = flag.String("s3-buffer-dir", "",
+		"Directory to buffer upload parts in instead of memory")
+	s3PresignExpiry = flag.Duration("s3-presign-expiry", 0,
+		"Redirect downloads to presigned S3 URLs valid for this duration")
+	contentAddressed = flag.Bool("content-addressed", false,
+		"Store identical ACIs once under their digest")
+	catalogBackend = flag.String("catalog", "",
+		"Serve listings from a \"memory\" or \"etcd\" metadata catalog")
+	cacheListings = flag.Bool("cache", false,
+		"Keep listings in memory, invalidated through etcd on changes")
+	reloadTemplates = flag.Bool("reload-templates", false,
+		"Parse the templates again whenever they change, for development")
+	discoveryPrefixes = flag.String("discovery-prefixes", "",
+		"Comma separated image name prefixes meta discovery is answered for")
+	resolveLatest = flag.Bool("resolve-latest", false,
+		"Serve the highest semantic version released for \"latest\"")
+	webhooks = flag.String("webhooks", "",
+		"Path to a JSON file listing the webhooks to notify")
+	webhookLog = flag.String("webhook-log", "",
+		"Path to the file webhook deliveries are logged to, stdout by default")
+	eventBroker = flag.String("events", "",
+		"Stream events at /api/v1/events through a \"memory\" or \"etcd\" broker")
+	eventTTL = flag.Duration("events-ttl", time.Hour,
+		"How long the etcd broker keeps the events clients can resume from")
+	auditLog = flag.String("audit", "",
+		"Record mutating operations to \"stdout\", a \"file\" or the \"storage\"")
+	auditFile = flag.String("audit-file", "audit.log",
+		"Path to the file the audit log is appended to with -audit file")
+	admins = flag.String("admins", "",
+		"Comma separated principals allowed to query the audit log, list every upload and see or cancel the ones of others, none by default")
+	uploadRate = flag.Float64("upload-rate", 0,
+		"Uploads each client may start per second, unlimited if 0")
+	uploadBurst = flag.Int("upload-burst", 5,
+		"Uploads each client may start at once within -upload-rate")
+	downloadRate = flag.Float64("download-rate", 0,
+		"Downloads each client may make per second, unlimited if 0")
+	downloadBurst = flag.Int("download-burst", 20,
+		"Downloads each client may make at once within -download-rate")
+	maxSessions = flag.Int("max-upload-sessions", 0,
+		"Upload sessions open at once, unlimited if 0")
+	maxClientSessions = flag.Int("max-client-upload-sessions", 0,
+		"Upload sessions open at once per client, unlimited if 0")
+	sessionTimeout = flag.Duration("upload-session-timeout", time.Hour,
+		"How long upload sessions stay open before being cancelled, forever if 0")
+	uploadGrace = flag.Duration("upload-grace", 10*time.Minute,
+		"How long finished uploads are remembered for retried completions")
+	maxTransfers = flag.Int("max-transfers", 0,
+		"ACI uploads in progress at once, unlimited if 0")
+	maxClientTransfers = flag.Int("max-client-transfers", 0,
+		"ACI uploads in progress at once per client, unlimited if 0")
+	maxManifestSize = flag.Int64("max-manifest-size", 1<<20,
+		"Maximum size of the uploaded manifests, unlimited if 0")
+	maxSignatureSize = flag.Int64("max-signature-size", 64<<10,
+		"Maximum size of the uploaded signatures, unlimited if 0")
+	maxACISize = flag.Int64("max-aci-size", 0,
+		"Maximum size of the uploaded ACIs, unlimited if 0")
+	quotas = flag.String("quotas", "",
+		"Path to a JSON file listing the byte quotas of the namespaces")
+	retentionRules = flag.String("retention", "",
+		"Path to a JSON file listing the retention rules gc applies")
+	dryRun = flag.Bool("dry-run", false,
+		"Make gc report what it would delete without deleting it")
 )
 
 func usage() {
 	fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
 	fmt.Fprintf(os.Stderr,
 		"acserver SERVER_NAME ACI_DIRECTORY TEMPLATE_DIRECTORY\n")
+	fmt.Fprintf(os.Stderr, "acserver -catalog etcd reindex\n")
+	fmt.Fprintf(os.Stderr, "acserver -retention RULES [-dry-run] gc\n")
 	fmt.Fprintf(os.Stderr, "Flags:\n")
 	flag.PrintDefaults()
 }
@@ -57,6 +155,22 @@ func main() {
 	flag.Parse()
 	args := flag.Args()
 
+	if len(args) == 1 && (args[0] == "reindex" || args[0] == "gc") {
+		command := reindex
+
+		if args[0] == "gc" {
+			command = gc
+		}
+
+		// Scripts and cron jobs need to tell the failures.
+		if err := command(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", args[0], err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
 	if len(args) != 3 {
 		usage()
 		return
@@ -80,35 +194,391 @@ func main() {
 	serverName = args[0]
 	directory = args[1]
 	templateDir = args[2]
-	auth, err := aws.EnvAuth()
+
+	s3Store, err := newS3Storage()
 
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v", err)
 		return
 	}
 
-	store, err = s3.NewStorage(auth, aws.USEast, "aci-repository")
+	store, err = newStore(s3Store)
 
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v", err)
 		return
 	}
 
-	backend, err = etcd.NewBackend([]string{"http://127.0.0.1:2379"}, "/acis")
+	backend, err = etcd.NewBackend(etcdEndpoints, "/acis")
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v", err)
+		return
+	}
+
+	if *tokens != "" {
+		authenticator, err = static.NewAuthenticator(*tokens)
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v", err)
+			return
+		}
+	}
+
+	var sinks events.Sinks
+
+	if *webhooks != "" {
+		d, err := newWebhookDispatcher()
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v", err)
+			return
+		}
+
+		sinks = append(sinks, d)
+	}
+
+	broker, err := newBroker()
 
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v", err)
 		return
 	}
 
+	auditSink, err := newAuditSink(s3Store)
+
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v", err)
 		return
 	}
 
-	mux := api.NewServerMux(store, backend, templateDir, serverName, *https)
+	cfg := api.Config{
+		Store:             store,
+		Backend:           backend,
+		Authenticator:     authenticator,
+		Events:            sinks,
+		Broker:            broker,
+		Audit:             auditSink,
+		Admins:            splitList(*admins),
+		TemplateDir:       templateDir,
+		ServerName:        serverName,
+		HTTPS:             *https,
+		ReloadTemplates:   *reloadTemplates,
+		DiscoveryPrefixes: splitList(*discoveryPrefixes),
+		ResolveLatest:     *resolveLatest,
+		MaxManifestSize:   *maxManifestSize,
+		MaxSignatureSize:  *maxSignatureSize,
+		MaxACISize:        *maxACISize,
+		UploadTimeout:     *sessionTimeout,
+		UploadGrace:       *uploadGrace,
+	}
+
+	if *quotas != "" {
+		rules, err := quota.LoadRules(*quotas)
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v", err)
+			return
+		}
+
+		cfg.Quotas = quota.NewQuotas(rules)
+	}
+
+	if *uploadRate > 0 {
+		cfg.UploadRate = ratelimit.NewLimiter(*uploadRate, *uploadBurst)
+	}
+
+	if *downloadRate > 0 {
+		cfg.DownloadRate = ratelimit.NewLimiter(*downloadRate, *downloadBurst)
+	}
+
+	if *maxSessions > 0 || *maxClientSessions > 0 {
+		cfg.UploadSessions = ratelimit.NewSessions(
+			*maxClientSessions,
+			*maxSessions,
+			*sessionTimeout,
+		)
+	}
+
+	if *maxTransfers > 0 || *maxClientTransfers > 0 {
+		cfg.Transfers = ratelimit.NewConcurrency(*maxClientTransfers, *maxTransfers)
+	}
+
+	mux := api.NewServerMux(cfg)
+
+	go func() {
+		for range time.Tick(uploadSweepInterval) {
+			if err := mux.SweepUploads(); err != nil {
+				log.Printf("upload sweep: %v", err)
+			}
+		}
+	}()
+
 	http.ListenAndServe(
 		fmt.Sprintf(":%d", *port),
 		handlers.LoggingHandler(os.Stdout, mux),
 	)
 }
+
+func splitList(v string) []string {
+	res := []string{}
+
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			res = append(res, s)
+		}
+	}
+
+	return res
+}
+
+func newWebhookDispatcher() (*webhook.Dispatcher, error) {
+	hooks, err := webhook.LoadHooks(*webhooks)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var log io.Writer = os.Stdout
+
+	if *webhookLog != "" {
+		f, err := os.OpenFile(
+			*webhookLog,
+			os.O_CREATE|os.O_WRONLY|os.O_APPEND,
+			0644,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		log = f
+	}
+
+	return webhook.NewDispatcher(hooks, log, webhook.Options{}), nil
+}
+
+func newBroker() (events.Broker, error) {
+	switch *eventBroker {
+	case "":
+		return nil, nil
+	case "memory":
+		return eventsmemory.NewBroker(eventHistory)
+	case "etcd":
+		return eventsetcd.NewBroker(
+			etcdEndpoints,
+			"/events",
+			*eventTTL,
+			eventHistory,
+		)
+	}
+
+	return nil, fmt.Errorf("unknown event broker %q", *eventBroker)
+}
+
+func newAuditSink(s3Store *s3.Storage) (audit.Sink, error) {
+	switch *auditLog {
+	case "":
+		return nil, nil
+	case "stdout":
+		return audit.NewWriterSink(os.Stdout), nil
+	case "file":
+		return audit.NewFileSink(*auditFile)
+	case "storage":
+		return audit.NewStorageSink(s3Store), nil
+	}
+
+	return nil, fmt.Errorf("unknown audit log %q", *auditLog)
+}
+
+func newS3Storage() (*s3.Storage, error) {
+	auth, err := aws.EnvAuth()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return s3.NewStorage(
+		auth,
+		aws.USEast,
+		"aci-repository",
+		s3.Options{
+			PartSize:         *s3PartSize,
+			BufferDir:        *s3BufferDir,
+			PresignExpiry:    *s3PresignExpiry,
+			ContentAddressed: *contentAddressed,
+		},
+	)
+}
+
+// newStore wraps the S3 storage with the catalog and the cache, if enabled.
+func newStore(s3Store *s3.Storage) (storage.Storage, error) {
+	var res storage.Storage = s3Store
+
+	if *catalogBackend != "" {
+		c, err := newCatalog(s3Store)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// A memory catalog starts empty every time.
+		if *catalogBackend == "memory" {
+			if _, err := c.Reindex(); err != nil {
+				return nil, err
+			}
+		}
+
+		res = c
+	}
+
+	if *cacheListings {
+		notifier, err := cacheetcd.NewNotifier(etcdEndpoints, "/cache/generation")
+
+		if err != nil {
+			return nil, err
+		}
+
+		res = cache.NewStorage(res, notifier)
+	}
+
+	return res, nil
+}
+
+func newCatalog(store storage.Storage) (*catalog.Storage, error) {
+	var (
+		c   catalog.Catalog
+		err error
+	)
+
+	switch *catalogBackend {
+	case "memory":
+		c, err = memory.NewCatalog()
+	case "etcd":
+		c, err = catalogetcd.NewCatalog(etcdEndpoints, "/catalog")
+	default:
+		err = fmt.Errorf("unknown catalog %q", *catalogBackend)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return catalog.NewStorage(store, c), nil
+}
+
+func reindex() error {
+	if *catalogBackend == "" {
+		return fmt.Errorf("reindex requires a -catalog")
+	}
+
+	s3Store, err := newS3Storage()
+
+	if err != nil {
+		return err
+	}
+
+	c, err := newCatalog(s3Store)
+
+	if err != nil {
+		return err
+	}
+
+	n, err := c.Reindex()
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%d files indexed\n", n)
+
+	return nil
+}
+
+func gc() error {
+	if *retentionRules == "" {
+		return fmt.Errorf("gc requires -retention rules")
+	}
+
+	rules, err := retention.LoadRules(*retentionRules)
+
+	if err != nil {
+		return err
+	}
+
+	s3Store, err := newS3Storage()
+
+	if err != nil {
+		return err
+	}
+
+	store, err := newStore(s3Store)
+
+	if err != nil {
+		return err
+	}
+
+	// The deletions are published and audited as the ones of the API.
+	sinks := retention.Sinks{}
+	published := events.Sinks{}
+
+	if *webhooks != "" {
+		d, err := newWebhookDispatcher()
+
+		if err != nil {
+			return err
+		}
+
+		defer d.Wait()
+		published = append(published, d)
+	}
+
+	broker, err := newBroker()
+
+	if err != nil {
+		return err
+	} else if broker != nil {
+		published = append(published, broker)
+	}
+
+	if len(published) > 0 {
+		sinks.Events = published
+	}
+
+	if sinks.Audit, err = newAuditSink(s3Store); err != nil {
+		return err
+	}
+
+	r, err := retention.Collect(
+		store,
+		retention.NewPolicy(rules),
+		*dryRun,
+		time.Now(),
+		sinks,
+	)
+
+	if r != nil {
+		verb := "deleted"
+
+		if r.DryRun {
+			verb = "would delete"
+		}
+
+		for _, d := range r.Deletions {
+			fmt.Printf("%s %s (%s)\n", verb, d.File, d.Reason)
+		}
+
+		for _, b := range r.Blobs {
+			fmt.Printf("%s blob %s (%d bytes)\n", verb, b.Digest, b.Size)
+		}
+
+		fmt.Printf(
+			"%d files and %d blobs %s\n",
+			len(r.Deletions),
+			len(r.Blobs),
+			verb,
+		)
+	}
+
+	return err
+}
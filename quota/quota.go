@@ -0,0 +1,206 @@
+package quota
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/appc/acserver/aci"
+)
+
+var ErrTooLarge = errors.New("Upload exceeds the maximum size")
+
+// Rule limits the bytes the images of a namespace, a name prefix ending at a
+// path separator, may take.
+type Rule struct {
+	Namespace string `json:"namespace"`
+	Bytes     int64  `json:"bytes"`
+}
+
+// LoadRules reads a JSON array of rules.
+func LoadRules(path string) ([]Rule, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	rules := []Rule{}
+
+	if err := json.NewDecoder(f).Decode(&rules); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return rules, nil
+}
+
+// Usage reports the bytes taken by the published images of a namespace, and
+// its quota if it has one. The files holding the same ACI, as the tags of a
+// version, take its size once.
+type Usage struct {
+	Namespace string `json:"namespace"`
+	Files     int    `json:"files"`
+	Bytes     int64  `json:"bytes"`
+	Quota     int64  `json:"quota,omitempty"`
+}
+
+type Quotas struct {
+	rules []Rule
+}
+
+func NewQuotas(rules []Rule) *Quotas {
+	rules = append([]Rule{}, rules...)
+
+	// The most specific namespaces win.
+	sort.Sort(byLength(rules))
+
+	return &Quotas{rules}
+}
+
+// Namespace returns the namespace of an image: the most specific one with a
+// quota, or the first component of its name.
+func (q *Quotas) Namespace(name string) string {
+	if r := q.rule(name); r != nil {
+		return r.Namespace
+	}
+
+	return strings.SplitN(name, "/", 2)[0]
+}
+
+// Applies reports whether the namespace of an image has a quota.
+func (q *Quotas) Applies(name string) bool {
+	return q.rule(name) != nil
+}
+
+// Remaining returns the bytes the image may still take, or false if its
+// namespace has no quota.
+func (q *Quotas) Remaining(acis []aci.Aci, name string) (int64, bool) {
+	r := q.rule(name)
+
+	if r == nil {
+		return 0, false
+	}
+
+	for _, u := range q.Usage(acis) {
+		if u.Namespace == r.Namespace {
+			if u.Bytes >= r.Bytes {
+				return 0, true
+			}
+
+			return r.Bytes - u.Bytes, true
+		}
+	}
+
+	return r.Bytes, true
+}
+
+// Usage adds up the published images per namespace, the namespaces with a
+// quota are all reported.
+func (q *Quotas) Usage(acis []aci.Aci) []Usage {
+	usage := map[string]*Usage{}
+	digests := map[string]bool{}
+
+	for _, r := range q.rules {
+		usage[r.Namespace] = &Usage{Namespace: r.Namespace, Quota: r.Bytes}
+	}
+
+	for _, a := range acis {
+		ns := q.Namespace(a.Name)
+		u, ok := usage[ns]
+
+		if !ok {
+			u = &Usage{Namespace: ns}
+			usage[ns] = u
+		}
+
+		for _, d := range a.Details {
+			u.Files++
+
+			if d.Digest != "" {
+				if digests[ns+" "+d.Digest] {
+					continue
+				}
+
+				digests[ns+" "+d.Digest] = true
+			}
+
+			u.Bytes += d.Size
+		}
+	}
+
+	res := []Usage{}
+
+	for _, u := range usage {
+		res = append(res, *u)
+	}
+
+	sort.Sort(byNamespace(res))
+
+	return res
+}
+
+func (q *Quotas) rule(name string) *Rule {
+	for i, r := range q.rules {
+		ns := strings.TrimSuffix(r.Namespace, "/")
+
+		if name == ns || strings.HasPrefix(name, ns+"/") {
+			return &q.rules[i]
+		}
+	}
+
+	return nil
+}
+
+type byLength []Rule
+
+func (r byLength) Len() int           { return len(r) }
+func (r byLength) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r byLength) Less(i, j int) bool { return len(r[i].Namespace) > len(r[j].Namespace) }
+
+type byNamespace []Usage
+
+func (u byNamespace) Len() int           { return len(u) }
+func (u byNamespace) Swap(i, j int)      { u[i], u[j] = u[j], u[i] }
+func (u byNamespace) Less(i, j int) bool { return u[i].Namespace < u[j].Namespace }
+
+// LimitedReader fails with Err, ErrTooLarge by default, once more than N
+// bytes were read.
+type LimitedReader struct {
+	R   io.Reader
+	N   int64
+	Err error
+	// Exceeded tells whether the reader failed because of the limit.
+	Exceeded bool
+}
+
+func LimitReader(r io.Reader, n int64) *LimitedReader {
+	return &LimitedReader{R: r, N: n, Err: ErrTooLarge}
+}
+
+func (l *LimitedReader) Read(p []byte) (int, error) {
+	if l.Exceeded {
+		return 0, l.Err
+	}
+
+	// One more byte tells exactly reaching the limit from exceeding it.
+	if int64(len(p))-1 > l.N {
+		p = p[:l.N+1]
+	}
+
+	n, err := l.R.Read(p)
+
+	if int64(n) > l.N {
+		l.Exceeded = true
+		return int(l.N), l.Err
+	}
+
+	l.N -= int64(n)
+
+	return n, err
+}
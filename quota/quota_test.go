@@ -0,0 +1,85 @@
+package quota
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/appc/acserver/aci"
+)
+
+func TestUsage(t *testing.T) {
+	q := NewQuotas(
+		[]Rule{
+			{Namespace: "example.com", Bytes: 1000},
+			{Namespace: "example.com/ci/", Bytes: 100},
+			{Namespace: "example.com/empty", Bytes: 10},
+		},
+	)
+
+	acis := []aci.Aci{
+		{Name: "example.com/app", Details: []aci.AciDetails{{Size: 300}, {Size: 200}}},
+		{Name: "example.com/lib", Details: []aci.AciDetails{{Size: 20, Digest: "sha512-a"}, {Size: 20, Digest: "sha512-a"}}},
+		{Name: "example.com/ci/lib", Details: []aci.AciDetails{{Size: 20, Digest: "sha512-a"}}},
+		{Name: "example.com/ci/build", Details: []aci.AciDetails{{Size: 150}}},
+		{Name: "example.com/cix", Details: []aci.AciDetails{{Size: 1}}},
+		{Name: "other.com/app", Details: []aci.AciDetails{{Size: 42}}},
+	}
+
+	e := []Usage{
+		{Namespace: "example.com", Files: 5, Bytes: 521, Quota: 1000},
+		{Namespace: "example.com/ci/", Files: 2, Bytes: 170, Quota: 100},
+		{Namespace: "example.com/empty", Quota: 10},
+		{Namespace: "other.com", Files: 1, Bytes: 42},
+	}
+
+	if u := q.Usage(acis); !reflect.DeepEqual(u, e) {
+		t.Errorf("Wrong usage: %+v", u)
+	}
+
+	for _, tt := range []struct {
+		name  string
+		left  int64
+		quota bool
+	}{
+		{"example.com/new", 479, true},
+		{"example.com/ci/build", 0, true},
+		{"example.com/empty", 10, true},
+		{"other.com/app", 0, false},
+	} {
+		left, ok := q.Remaining(acis, tt.name)
+
+		if left != tt.left || ok != tt.quota {
+			t.Errorf("%s: wrong remaining bytes: %d %v", tt.name, left, ok)
+		}
+	}
+}
+
+func TestLimitReader(t *testing.T) {
+	for _, tt := range []struct {
+		in       string
+		n        int64
+		exceeded bool
+	}{
+		{"", 0, false},
+		{"12345", 5, false},
+		{"123456", 5, true},
+		{"123456", 0, true},
+	} {
+		r := LimitReader(strings.NewReader(tt.in), tt.n)
+		blob, err := ioutil.ReadAll(r)
+
+		if r.Exceeded != tt.exceeded {
+			t.Errorf("%q %d: wrong limit: %v", tt.in, tt.n, r.Exceeded)
+		}
+
+		if tt.exceeded && (err != ErrTooLarge || int64(len(blob)) != tt.n) {
+			t.Errorf("%q %d: wrong read: %q %v", tt.in, tt.n, blob, err)
+		}
+
+		if !tt.exceeded && (err != nil || string(blob) != tt.in) {
+			t.Errorf("%q %d: wrong read: %q %v", tt.in, tt.n, blob, err)
+		}
+	}
+}
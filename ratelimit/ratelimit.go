@@ -0,0 +1,205 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// idleBuckets is how many buckets a Limiter keeps before forgetting the full
+// ones, which are equivalent to new ones.
+const idleBuckets = 1024
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter is a token bucket per client: each one may burst Burst requests
+// and is refilled at Rate requests per second.
+type Limiter struct {
+	Rate  float64
+	Burst int
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+func NewLimiter(rate float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &Limiter{
+		Rate:    rate,
+		Burst:   burst,
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Allow takes a token from the bucket of the client, or returns how long it
+// has to wait for the next one.
+func (l *Limiter) Allow(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[client]
+
+	if !ok {
+		if len(l.buckets) >= idleBuckets {
+			l.forgetFull(now)
+		}
+
+		b = &bucket{tokens: float64(l.Burst), last: now}
+		l.buckets[client] = b
+	}
+
+	b.tokens = math.Min(
+		float64(l.Burst),
+		b.tokens+now.Sub(b.last).Seconds()*l.Rate,
+	)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	if l.Rate <= 0 {
+		return false, time.Hour
+	}
+
+	return false, time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+}
+
+func (l *Limiter) forgetFull(now time.Time) {
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.Rate >= float64(l.Burst) {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// Concurrency caps the number of operations in progress per client and in
+// total, 0 meaning no limit.
+type Concurrency struct {
+	PerClient int
+	Global    int
+
+	mu     sync.Mutex
+	counts map[string]int
+	total  int
+}
+
+func NewConcurrency(perClient, global int) *Concurrency {
+	return &Concurrency{
+		PerClient: perClient,
+		Global:    global,
+		counts:    make(map[string]int),
+	}
+}
+
+// Acquire starts an operation for the client if neither limit is reached,
+// it has to be released once done.
+func (c *Concurrency) Acquire(client string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if (c.Global > 0 && c.total >= c.Global) ||
+		(c.PerClient > 0 && c.counts[client] >= c.PerClient) {
+		return false
+	}
+
+	c.counts[client]++
+	c.total++
+
+	return true
+}
+
+func (c *Concurrency) Release(client string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.counts[client] == 0 {
+		return
+	}
+
+	if c.counts[client]--; c.counts[client] == 0 {
+		delete(c.counts, client)
+	}
+
+	c.total--
+}
+
+type session struct {
+	client string
+	opened time.Time
+}
+
+// Sessions caps the number of upload sessions open per client and in total.
+// Sessions left open for longer than the timeout, abandoned by their
+// clients, stop counting.
+type Sessions struct {
+	Timeout time.Duration
+
+	concurrency *Concurrency
+	mu          sync.Mutex
+	sessions    map[uint64]session
+	now         func() time.Time
+}
+
+func NewSessions(perClient, global int, timeout time.Duration) *Sessions {
+	return &Sessions{
+		Timeout:     timeout,
+		concurrency: NewConcurrency(perClient, global),
+		sessions:    make(map[uint64]session),
+		now:         time.Now,
+	}
+}
+
+// Reserve counts a session about to be opened for the client, it has to be
+// either opened or released.
+func (s *Sessions) Reserve(client string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Timeout > 0 {
+		deadline := s.now().Add(-s.Timeout)
+
+		for id, ses := range s.sessions {
+			if ses.opened.Before(deadline) {
+				s.concurrency.Release(ses.client)
+				delete(s.sessions, id)
+			}
+		}
+	}
+
+	return s.concurrency.Acquire(client)
+}
+
+// Open binds a reserved session to its upload ID.
+func (s *Sessions) Open(client string, id uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[id] = session{client, s.now()}
+}
+
+// Release gives back a reservation that wasn't opened.
+func (s *Sessions) Release(client string) {
+	s.concurrency.Release(client)
+}
+
+// Close stops counting the session of an upload, if it still is.
+func (s *Sessions) Close(id uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ses, ok := s.sessions[id]; ok {
+		s.concurrency.Release(ses.client)
+		delete(s.sessions, id)
+	}
+}
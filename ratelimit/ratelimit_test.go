@@ -0,0 +1,113 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time {
+	return c.t
+}
+
+func TestLimiter(t *testing.T) {
+	c := &clock{time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC)}
+	l := NewLimiter(0.5, 2)
+	l.now = c.now
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("ci"); !ok {
+			t.Fatalf("Request %d wasn't allowed", i)
+		}
+	}
+
+	if ok, wait := l.Allow("ci"); ok || wait != 2*time.Second {
+		t.Errorf("Wrong limit: %v %v", ok, wait)
+	}
+
+	if ok, _ := l.Allow("10.0.0.1"); !ok {
+		t.Errorf("The clients share a bucket")
+	}
+
+	c.t = c.t.Add(time.Second)
+
+	if ok, wait := l.Allow("ci"); ok || wait != time.Second {
+		t.Errorf("Wrong limit: %v %v", ok, wait)
+	}
+
+	c.t = c.t.Add(time.Second)
+
+	if ok, _ := l.Allow("ci"); !ok {
+		t.Errorf("The bucket wasn't refilled")
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	c := NewConcurrency(2, 3)
+
+	for _, tt := range []struct {
+		client string
+		ok     bool
+	}{
+		{"ci", true},
+		{"ci", true},
+		{"ci", false},
+		{"ops", true},
+		{"dev", false},
+	} {
+		if ok := c.Acquire(tt.client); ok != tt.ok {
+			t.Errorf("%s: wrong acquisition: %v", tt.client, ok)
+		}
+	}
+
+	c.Release("ci")
+
+	if !c.Acquire("dev") {
+		t.Errorf("The release wasn't counted")
+	}
+
+	c.Release("unknown")
+
+	if c.Acquire("dev") {
+		t.Errorf("Releasing an unknown client was counted")
+	}
+}
+
+func TestSessions(t *testing.T) {
+	c := &clock{time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC)}
+	s := NewSessions(1, 0, time.Hour)
+	s.now = c.now
+
+	if !s.Reserve("ci") {
+		t.Fatal("The first session wasn't reserved")
+	}
+
+	s.Open("ci", 1)
+
+	if s.Reserve("ci") {
+		t.Errorf("The per client limit wasn't enforced")
+	}
+
+	s.Close(1)
+
+	if !s.Reserve("ci") {
+		t.Errorf("The closed session still counts")
+	}
+
+	s.Open("ci", 2)
+	c.t = c.t.Add(2 * time.Hour)
+
+	if !s.Reserve("ci") {
+		t.Errorf("The abandoned session still counts")
+	}
+
+	s.Release("ci")
+	s.Close(2)
+
+	if !s.Reserve("ci") {
+		t.Errorf("The expired session was released twice")
+	}
+}
@@ -0,0 +1,415 @@
+package retention
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/appc/acserver/aci"
+	"github.com/appc/acserver/audit"
+	"github.com/appc/acserver/events"
+	"github.com/appc/acserver/semver"
+	"github.com/appc/acserver/storage"
+)
+
+// Rule decides which versions of the images whose name starts with a prefix
+// are kept. Only semantic versions are managed, tags never are, and the
+// versions a tag serves are kept whatever their age or rank.
+type Rule struct {
+	Prefix string `json:"prefix"`
+	// KeepLast keeps the latest versions, every one if 0.
+	KeepLast int `json:"keep_last,omitempty"`
+	// DeleteTagged lets the versions a tag serves be deleted as the
+	// others.
+	DeleteTagged bool `json:"delete_tagged,omitempty"`
+	// PreReleaseDays deletes the pre-releases published longer ago, none
+	// if 0.
+	PreReleaseDays int `json:"prerelease_days,omitempty"`
+}
+
+// LoadRules reads a JSON array of rules.
+func LoadRules(path string) ([]Rule, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	rules := []Rule{}
+
+	if err := json.NewDecoder(f).Decode(&rules); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return rules, nil
+}
+
+// Deletion is a file of an image the rules don't keep.
+type Deletion struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	File    string `json:"file"`
+	Size    int64  `json:"size"`
+	Reason  string `json:"reason"`
+}
+
+type Policy struct {
+	rules []Rule
+}
+
+func NewPolicy(rules []Rule) *Policy {
+	rules = append([]Rule{}, rules...)
+
+	// The most specific prefixes win.
+	sort.Sort(byLength(rules))
+
+	return &Policy{rules}
+}
+
+func (p *Policy) rule(name string) *Rule {
+	for i, r := range p.rules {
+		if strings.HasPrefix(name, r.Prefix) {
+			return &p.rules[i]
+		}
+	}
+
+	return nil
+}
+
+type version struct {
+	version   string
+	semver    *semver.Version
+	published time.Time
+	tagged    bool
+	files     []aci.AciDetails
+}
+
+// Evaluate returns the files of the versions the rules don't keep, given
+// the files a tag serves.
+func (p *Policy) Evaluate(acis []aci.Aci, tagged map[string]bool, now time.Time) []Deletion {
+	res := []Deletion{}
+
+	for _, a := range acis {
+		r := p.rule(a.Name)
+
+		if r == nil {
+			continue
+		}
+
+		versions := versionsOf(a, tagged)
+
+		for i, v := range versions {
+			if v.tagged && !r.DeleteTagged {
+				continue
+			}
+
+			reason := ""
+
+			switch {
+			case r.KeepLast > 0 && i >= r.KeepLast:
+				reason = fmt.Sprintf("older than the %d latest versions", r.KeepLast)
+			case r.PreReleaseDays > 0 && v.semver.IsPreRelease() &&
+				now.Sub(v.published) > time.Duration(r.PreReleaseDays)*24*time.Hour:
+				reason = fmt.Sprintf("pre-release older than %d days", r.PreReleaseDays)
+			default:
+				continue
+			}
+
+			for _, d := range v.files {
+				res = append(
+					res,
+					Deletion{
+						Name:    a.Name,
+						Version: v.version,
+						File:    d.File,
+						Size:    d.Size,
+						Reason:  reason,
+					},
+				)
+			}
+		}
+	}
+
+	return res
+}
+
+// versionsOf groups the files of the semantic versions of an image, latest
+// first.
+func versionsOf(a aci.Aci, tagged map[string]bool) []*version {
+	var (
+		res     = []*version{}
+		byValue = map[string]*version{}
+	)
+
+	for _, d := range a.Details {
+		s, err := semver.Parse(d.Version)
+
+		if err != nil {
+			continue
+		}
+
+		v := byValue[d.Version]
+
+		if v == nil {
+			v = &version{version: d.Version, semver: s}
+			byValue[d.Version] = v
+			res = append(res, v)
+		}
+
+		if d.LastModified.After(v.published) {
+			v.published = d.LastModified
+		}
+
+		v.tagged = v.tagged || tagged[d.File]
+		v.files = append(v.files, d)
+	}
+
+	sort.Sort(byVersion(res))
+
+	return res
+}
+
+// Tagged returns the files of the semantic versions whose ACI a tag serves.
+// The versions come from the histories the API records for the tags, or,
+// for the tags without one, from comparing the digests of their files with
+// the ones of the versions of the same platform.
+func Tagged(s storage.Storage, acis []aci.Aci) (map[string]bool, error) {
+	res := map[string]bool{}
+	md, _ := s.(storage.MetadataStore)
+
+	for _, a := range acis {
+		var (
+			served   = map[string]bool{}
+			digests  = map[string]bool{}
+			versions = map[string]string{}
+		)
+
+		for _, d := range a.Details {
+			if _, err := semver.Parse(d.Version); err == nil {
+				continue
+			}
+
+			v, ok := versions[d.Version]
+
+			if !ok {
+				var err error
+
+				if v, err = taggedVersion(md, a.Name, d.Version); err != nil {
+					return nil, err
+				}
+
+				versions[d.Version] = v
+			}
+
+			if v != "" {
+				served[d.OS+"/"+d.Arch+"/"+v] = true
+				continue
+			}
+
+			digest, err := digestOf(s, d)
+
+			if err != nil {
+				return nil, err
+			} else if digest != "" {
+				digests[d.OS+"/"+d.Arch+"/"+digest] = true
+			}
+		}
+
+		if len(served) == 0 && len(digests) == 0 {
+			continue
+		}
+
+		for _, d := range a.Details {
+			if _, err := semver.Parse(d.Version); err != nil {
+				continue
+			}
+
+			if served[d.OS+"/"+d.Arch+"/"+d.Version] {
+				res[d.File] = true
+				continue
+			}
+
+			if len(digests) == 0 {
+				continue
+			}
+
+			digest, err := digestOf(s, d)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if digests[d.OS+"/"+d.Arch+"/"+digest] {
+				res[d.File] = true
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// taggedVersion returns the version a tag serves according to the history
+// the API records in tags/<name>/<tag>.json, if any.
+func taggedVersion(md storage.MetadataStore, name, tag string) (string, error) {
+	if md == nil {
+		return "", nil
+	}
+
+	blob, err := md.GetMetadata("tags/" + name + "/" + tag + ".json")
+
+	if err == storage.ErrNotFound || err == storage.ErrNotSupported {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	history := []struct {
+		Version string `json:"version"`
+	}{}
+
+	if err := json.Unmarshal(blob, &history); err != nil {
+		return "", fmt.Errorf("%s/%s: %v", name, tag, err)
+	}
+
+	if len(history) == 0 {
+		return "", nil
+	}
+
+	return history[len(history)-1].Version, nil
+}
+
+func digestOf(s storage.Storage, d aci.AciDetails) (string, error) {
+	if d.Digest != "" {
+		return d.Digest, nil
+	}
+
+	info, err := s.StatACI(d.File)
+
+	if err == storage.ErrNotFound {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	return info.Digest, nil
+}
+
+// Report lists what a collection deleted, or would delete in a dry run.
+type Report struct {
+	DryRun    bool           `json:"dry_run"`
+	Deletions []Deletion     `json:"deletions"`
+	Blobs     []storage.Blob `json:"blobs"`
+}
+
+// Sinks are told about the files Collect deletes, as the API tells about the
+// ones its clients delete. Both are optional.
+type Sinks struct {
+	Events events.Sink
+	Audit  audit.Sink
+}
+
+// Collect deletes the ACIs, signatures and manifests of the versions the
+// rules don't keep, then the blobs of the content addressed layout no name
+// references anymore, once storage.BlobGrace has passed. Nothing is deleted
+// in a dry run, which only reports the blobs already unreferenced.
+func Collect(s storage.Storage, p *Policy, dryRun bool, now time.Time, sinks Sinks) (*Report, error) {
+	acis, _, err := s.ListACIs()
+
+	if err != nil {
+		return nil, err
+	}
+
+	tagged, err := Tagged(s, acis)
+
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Report{DryRun: dryRun, Deletions: []Deletion{}, Blobs: []storage.Blob{}}
+
+	for _, d := range p.Evaluate(acis, tagged, now) {
+		if !dryRun {
+			err := s.DeleteACI(d.File)
+
+			if err == storage.ErrNotFound {
+				continue
+			}
+
+			sinks.deleted(d, err)
+
+			if err != nil {
+				return r, err
+			}
+		}
+
+		r.Deletions = append(r.Deletions, d)
+	}
+
+	b, ok := s.(storage.BlobStore)
+
+	if !ok {
+		return r, nil
+	}
+
+	blobs, err := storage.CollectBlobs(b, dryRun, now)
+
+	if err == storage.ErrNotSupported {
+		return r, nil
+	} else if err != nil {
+		return r, err
+	}
+
+	r.Blobs = blobs
+
+	return r, nil
+}
+
+// deleted publishes the deletion of a file and records it in the audit log,
+// failed or not.
+func (s Sinks) deleted(d Deletion, err error) {
+	if s.Events != nil && err == nil {
+		e := events.New(events.ImageDeleted, d.File)
+		e.Reason = d.Reason
+		s.Events.Publish(e)
+	}
+
+	if s.Audit == nil {
+		return
+	}
+
+	r := &audit.Record{
+		Time:    time.Now(),
+		Action:  "gc",
+		Image:   d.Name,
+		Version: d.Version,
+		Outcome: audit.Success,
+	}
+
+	if err != nil {
+		r.Outcome, r.Error = audit.Failure, err.Error()
+	}
+
+	if err := s.Audit.Write(r); err != nil {
+		log.Printf("audit: gc of %s not recorded: %v", d.File, err)
+	}
+}
+
+type byLength []Rule
+
+func (r byLength) Len() int           { return len(r) }
+func (r byLength) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r byLength) Less(i, j int) bool { return len(r[i].Prefix) > len(r[j].Prefix) }
+
+type byVersion []*version
+
+func (v byVersion) Len() int      { return len(v) }
+func (v byVersion) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v byVersion) Less(i, j int) bool {
+	return v[i].semver.Compare(v[j].semver) > 0
+}
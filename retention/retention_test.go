@@ -0,0 +1,177 @@
+package retention
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appc/acserver/aci"
+	"github.com/appc/acserver/audit"
+	"github.com/appc/acserver/events"
+	"github.com/appc/acserver/storage/filesystem"
+	"github.com/appc/acserver/upload"
+)
+
+func TestEvaluate(t *testing.T) {
+	now := time.Date(2015, 10, 19, 0, 0, 0, 0, time.UTC)
+	old := now.Add(-30 * 24 * time.Hour)
+
+	p := NewPolicy(
+		[]Rule{
+			{Prefix: "example.com/", KeepLast: 2},
+			{Prefix: "example.com/ci/", PreReleaseDays: 7},
+		},
+	)
+
+	acis := []aci.Aci{
+		{Name: "example.com/app", Details: []aci.AciDetails{
+			{Version: "1.0.0", OS: "linux", Arch: "amd64", File: "app-1.0.0-linux-amd64.aci", Size: 1},
+			{Version: "1.0.0", OS: "linux", Arch: "arm64", File: "app-1.0.0-linux-arm64.aci", Size: 2},
+			{Version: "1.1.0", OS: "linux", Arch: "amd64", File: "app-1.1.0-linux-amd64.aci"},
+			{Version: "1.2.0", OS: "linux", Arch: "amd64", File: "app-1.2.0-linux-amd64.aci"},
+			{Version: "1.10.0", OS: "linux", Arch: "amd64", File: "app-1.10.0-linux-amd64.aci"},
+			{Version: "stable", OS: "linux", Arch: "amd64", File: "app-stable-linux-amd64.aci"},
+		}},
+		{Name: "example.com/ci/build", Details: []aci.AciDetails{
+			{Version: "2.0.0-rc.1", File: "build-2.0.0-rc.1.aci", LastModified: old},
+			{Version: "2.0.0-rc.2", File: "build-2.0.0-rc.2.aci", LastModified: now},
+			{Version: "1.0.0", File: "build-1.0.0.aci", LastModified: old},
+		}},
+		{Name: "other.com/app", Details: []aci.AciDetails{
+			{Version: "1.0.0", File: "other-1.0.0.aci"},
+		}},
+	}
+
+	tagged := map[string]bool{"app-1.1.0-linux-amd64.aci": true}
+
+	e := []Deletion{
+		{Name: "example.com/app", Version: "1.0.0", File: "app-1.0.0-linux-amd64.aci", Size: 1, Reason: "older than the 2 latest versions"},
+		{Name: "example.com/app", Version: "1.0.0", File: "app-1.0.0-linux-arm64.aci", Size: 2, Reason: "older than the 2 latest versions"},
+		{Name: "example.com/ci/build", Version: "2.0.0-rc.1", File: "build-2.0.0-rc.1.aci", Reason: "pre-release older than 7 days"},
+	}
+
+	if d := p.Evaluate(acis, tagged, now); !reflect.DeepEqual(d, e) {
+		t.Errorf("Wrong deletions: %+v", d)
+	}
+
+	// Unless told otherwise, the versions a tag serves are kept.
+	p = NewPolicy([]Rule{{Prefix: "example.com/app", KeepLast: 2, DeleteTagged: true}})
+
+	if d := p.Evaluate(acis[:1], tagged, now); len(d) != 3 || d[0].Version != "1.1.0" {
+		t.Errorf("Tagged version kept: %+v", d)
+	}
+}
+
+type recordSinks struct {
+	events  []*events.Event
+	records []*audit.Record
+}
+
+func (s *recordSinks) Publish(e *events.Event) {
+	s.events = append(s.events, e)
+}
+
+func (s *recordSinks) Write(r *audit.Record) error {
+	s.records = append(s.records, r)
+	return nil
+}
+
+func (s *recordSinks) Query(audit.Filter) ([]*audit.Record, error) {
+	return nil, audit.ErrNotQueryable
+}
+
+func TestCollect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acserver-retention")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	s, err := filesystem.NewStorage(dir, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := `{"acKind": "ImageManifest", "name": "example.com/app", "labels": [
+		{"name": "version", "value": %q},
+		{"name": "os", "value": "linux"},
+		{"name": "arch", "value": "amd64"}
+	]}`
+
+	for i, v := range []string{"0.9.0", "1.0.0", "1.1.0", "1.2.0"} {
+		up := upload.Upload{
+			ID:     uint64(i),
+			Image:  "example.com/app-" + v + "-linux-amd64.aci",
+			Digest: "sha512-" + strings.Repeat(v[2:3], 128),
+		}
+
+		// Pushed without a digest, only its tag history tells it is tagged.
+		if v == "0.9.0" {
+			up.Digest = ""
+		}
+
+		for _, err := range []error{
+			s.UploadACI(up, strings.NewReader("aci")),
+			s.UploadASC(up, strings.NewReader("asc")),
+			s.UploadManifest(up, strings.NewReader(fmt.Sprintf(manifest, v))),
+			s.FinishUpload(up),
+		} {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if err := s.TagACI("example.com/app-1.0.0-linux-amd64.aci", "example.com/app-stable-linux-amd64.aci"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.TagACI("example.com/app-0.9.0-linux-amd64.aci", "example.com/app-legacy-linux-amd64.aci"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.PutMetadata("tags/example.com/app/legacy.json", []byte(`[{"version": "0.9.0"}]`)); err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewPolicy([]Rule{{Prefix: "example.com/", KeepLast: 1}})
+	sinks := &recordSinks{}
+
+	for _, dryRun := range []bool{true, false} {
+		r, err := Collect(s, p, dryRun, time.Now(), Sinks{sinks, sinks})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(r.Deletions) != 1 || r.Deletions[0].Version != "1.1.0" {
+			t.Errorf("dry run %v: wrong deletions: %+v", dryRun, r.Deletions)
+		}
+
+		if _, err := os.Stat(dir + "/example.com/app-1.1.0-linux-amd64.aci"); os.IsNotExist(err) != !dryRun {
+			t.Errorf("dry run %v: wrong deletion: %v", dryRun, err)
+		}
+	}
+
+	for _, ext := range []string{".asc", ".manifest"} {
+		if _, err := os.Stat(dir + "/example.com/app-1.1.0-linux-amd64.aci" + ext); !os.IsNotExist(err) {
+			t.Errorf("%s kept: %v", ext, err)
+		}
+	}
+
+	// Only the actual deletions are published and audited.
+	if len(sinks.events) != 1 || sinks.events[0].Type != events.ImageDeleted || sinks.events[0].Version != "1.1.0" {
+		t.Errorf("Wrong events: %+v", sinks.events)
+	}
+
+	if len(sinks.records) != 1 || sinks.records[0].Action != "gc" || sinks.records[0].Outcome != audit.Success {
+		t.Errorf("Wrong audit records: %+v", sinks.records)
+	}
+}
@@ -0,0 +1,200 @@
+// Package semver parses and orders versions following Semantic Versioning
+// 2.0.0, http://semver.org.
+package semver
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidVersion = errors.New("invalid semantic version")
+
+type Version struct {
+	Major      uint64
+	Minor      uint64
+	Patch      uint64
+	PreRelease []string
+	Build      []string
+}
+
+// Parse parses a version, tolerating the "v" prefix commonly found in tags.
+func Parse(v string) (*Version, error) {
+	v = strings.TrimPrefix(v, "v")
+	res := &Version{}
+
+	if i := strings.IndexByte(v, '+'); i >= 0 {
+		build, err := identifiers(v[i+1:], false)
+
+		if err != nil {
+			return nil, err
+		}
+
+		res.Build, v = build, v[:i]
+	}
+
+	if i := strings.IndexByte(v, '-'); i >= 0 {
+		pre, err := identifiers(v[i+1:], true)
+
+		if err != nil {
+			return nil, err
+		}
+
+		res.PreRelease, v = pre, v[:i]
+	}
+
+	tokens := strings.Split(v, ".")
+
+	if len(tokens) != 3 {
+		return nil, ErrInvalidVersion
+	}
+
+	for i, dst := range []*uint64{&res.Major, &res.Minor, &res.Patch} {
+		if !isNumeric(tokens[i]) {
+			return nil, ErrInvalidVersion
+		}
+
+		n, err := strconv.ParseUint(tokens[i], 10, 64)
+
+		if err != nil {
+			return nil, ErrInvalidVersion
+		}
+
+		*dst = n
+	}
+
+	return res, nil
+}
+
+// identifiers splits dot separated identifiers, numeric pre-release ones
+// must not have leading zeroes.
+func identifiers(v string, preRelease bool) ([]string, error) {
+	res := strings.Split(v, ".")
+
+	for _, id := range res {
+		if id == "" {
+			return nil, ErrInvalidVersion
+		}
+
+		for _, c := range id {
+			if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '-') {
+				return nil, ErrInvalidVersion
+			}
+		}
+
+		if preRelease && isDigits(id) && !isNumeric(id) {
+			return nil, ErrInvalidVersion
+		}
+	}
+
+	return res, nil
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isNumeric tells whether s is a number without leading zeroes.
+func isNumeric(s string) bool {
+	return isDigits(s) && (s == "0" || s[0] != '0')
+}
+
+func (v *Version) IsPreRelease() bool {
+	return len(v.PreRelease) > 0
+}
+
+func (v *Version) String() string {
+	s := strconv.FormatUint(v.Major, 10) + "." +
+		strconv.FormatUint(v.Minor, 10) + "." +
+		strconv.FormatUint(v.Patch, 10)
+
+	if len(v.PreRelease) > 0 {
+		s += "-" + strings.Join(v.PreRelease, ".")
+	}
+
+	if len(v.Build) > 0 {
+		s += "+" + strings.Join(v.Build, ".")
+	}
+
+	return s
+}
+
+// Compare returns -1, 0 or 1 if v has a lower, equal or higher precedence
+// than o. Build metadata doesn't take part in the precedence.
+func (v *Version) Compare(o *Version) int {
+	for _, c := range [][2]uint64{
+		{v.Major, o.Major},
+		{v.Minor, o.Minor},
+		{v.Patch, o.Patch},
+	} {
+		if c[0] != c[1] {
+			if c[0] < c[1] {
+				return -1
+			}
+
+			return 1
+		}
+	}
+
+	switch {
+	case len(v.PreRelease) == 0 && len(o.PreRelease) == 0:
+		return 0
+	case len(v.PreRelease) == 0:
+		return 1
+	case len(o.PreRelease) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(v.PreRelease) && i < len(o.PreRelease); i++ {
+		if c := compareIdentifiers(v.PreRelease[i], o.PreRelease[i]); c != 0 {
+			return c
+		}
+	}
+
+	return compareInts(len(v.PreRelease), len(o.PreRelease))
+}
+
+func compareIdentifiers(a, b string) int {
+	an, bn := isDigits(a), isDigits(b)
+
+	switch {
+	case an && bn:
+		if c := compareInts(len(a), len(b)); c != 0 {
+			return c
+		}
+	case an:
+		return -1
+	case bn:
+		return 1
+	}
+
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+
+	return 0
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+
+	return 0
+}
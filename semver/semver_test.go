@@ -0,0 +1,79 @@
+package semver
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		in  string
+		out *Version
+	}{
+		{"1.2.3", &Version{Major: 1, Minor: 2, Patch: 3}},
+		{"v0.10.0", &Version{Minor: 10}},
+		{"1.0.0-rc.1", &Version{Major: 1, PreRelease: []string{"rc", "1"}}},
+		{"1.0.0-x-y.0+git.abc", &Version{Major: 1, PreRelease: []string{"x-y", "0"}, Build: []string{"git", "abc"}}},
+		{"1.0.0+001", &Version{Major: 1, Build: []string{"001"}}},
+		{"latest", nil},
+		{"1.0", nil},
+		{"1.0.0.0", nil},
+		{"01.0.0", nil},
+		{"1.0.0-01", nil},
+		{"1.0.0-", nil},
+		{"1.0.0-rc..1", nil},
+		{"1.0.0+b_1", nil},
+	} {
+		v, err := Parse(tt.in)
+
+		if tt.out == nil {
+			if err != ErrInvalidVersion {
+				t.Errorf("%s: expected an error, got %+v", tt.in, v)
+			}
+
+			continue
+		}
+
+		if err != nil || !reflect.DeepEqual(v, tt.out) {
+			t.Errorf("%s: wrong parsing: %+v %v", tt.in, v, err)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	// From the lowest precedence to the highest, as in the specification.
+	ordered := []string{
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+		"1.0.1",
+		"1.2.0",
+		"1.10.0",
+		"2.0.0",
+	}
+
+	for i := range ordered {
+		for j := range ordered {
+			a, _ := Parse(ordered[i])
+			b, _ := Parse(ordered[j])
+
+			expected := compareInts(i, j)
+
+			if c := a.Compare(b); c != expected {
+				t.Errorf("%s vs %s: got %d, expected %d", ordered[i], ordered[j], c, expected)
+			}
+		}
+	}
+
+	a, _ := Parse("1.0.0+build.1")
+	b, _ := Parse("1.0.0+build.2")
+
+	if a.Compare(b) != 0 {
+		t.Errorf("Build metadata should be ignored")
+	}
+}
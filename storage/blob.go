@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"regexp"
+	"sort"
+	"time"
+)
+
+// BlobGrace is how long blobs are kept after being stored or pushed again,
+// whether names reference them or not, so that the pushes completing during
+// a collection find them.
+const BlobGrace = 10 * time.Minute
+
+var digestRegexp = regexp.MustCompile(`^sha512-[0-9a-f]{128}$`)
+
+// Blob is an ACI stored once under its digest, referenced by the names it
+// was published or tagged as.
+type Blob struct {
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+	Refs   int    `json:"refs"`
+	// Modified is when the blob was last stored, pushes of the same content
+	// refresh it.
+	Modified time.Time `json:"modified"`
+}
+
+// BlobStore is implemented by the backends able to store the ACIs under
+// their digest, deduplicating identical ones. They return ErrNotSupported
+// when the layout is disabled.
+type BlobStore interface {
+	// ListBlobs returns the blobs ordered by digest, with the number of
+	// names referencing them counted after listing them.
+	ListBlobs() ([]Blob, error)
+	// DeleteBlob removes a listed blob, it fails with ErrConflict if it was
+	// modified since. The references aren't counted again.
+	DeleteBlob(Blob) error
+	// DownloadBlob reads the blob of a digest.
+	DownloadBlob(string) (ReadSeekCloser, error)
+}
+
+func ValidDigest(digest string) bool {
+	return digestRegexp.MatchString(digest)
+}
+
+// CollectBlobs deletes the blobs no name references anymore, and that weren't
+// modified within BlobGrace of now, and returns them. They are only reported
+// with dryRun.
+func CollectBlobs(b BlobStore, dryRun bool, now time.Time) ([]Blob, error) {
+	blobs, err := b.ListBlobs()
+
+	if err != nil {
+		return nil, err
+	}
+
+	res := []Blob{}
+
+	for _, blob := range blobs {
+		if blob.Refs > 0 || now.Sub(blob.Modified) < BlobGrace {
+			continue
+		}
+
+		if !dryRun {
+			// Pushed again since listed.
+			if err := b.DeleteBlob(blob); err == ErrConflict {
+				continue
+			} else if err != nil {
+				return res, err
+			}
+		}
+
+		res = append(res, blob)
+	}
+
+	return res, nil
+}
+
+type byDigest []Blob
+
+func (b byDigest) Len() int           { return len(b) }
+func (b byDigest) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byDigest) Less(i, j int) bool { return b[i].Digest < b[j].Digest }
+
+// SortBlobs orders blobs by digest.
+func SortBlobs(blobs []Blob) {
+	sort.Sort(byDigest(blobs))
+}
@@ -1,20 +1,36 @@
 package filesystem
 
 import (
+	"bytes"
 	"io"
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/appc/acserver/aci"
 	"github.com/appc/acserver/storage"
 	"github.com/appc/acserver/upload"
 )
 
+const (
+	// metadataDir holds the metadata documents, listings skip it as any
+	// other hidden directory.
+	metadataDir = ".metadata"
+	// blobsDir holds the ACIs of the content addressed layout, named after
+	// their digest. Their names are hard links to them, so that the blobs
+	// link count is their reference count.
+	blobsDir = ".blobs"
+)
+
 type Storage struct {
-	directory string
-	gpgPubKey *string
+	directory        string
+	gpgPubKey        *string
+	contentAddressed bool
 }
 
 func NewStorage(directory string, gpgPubKey *string) (*Storage, error) {
@@ -24,7 +40,25 @@ func NewStorage(directory string, gpgPubKey *string) (*Storage, error) {
 		return nil, err
 	}
 
-	return &Storage{directory, gpgPubKey}, nil
+	return &Storage{directory: directory, gpgPubKey: gpgPubKey}, nil
+}
+
+// NewContentAddressedStorage returns a storage keeping a single copy of
+// identical ACIs, whatever the names they are published as.
+func NewContentAddressedStorage(directory string, gpgPubKey *string) (*Storage, error) {
+	s, err := NewStorage(directory, gpgPubKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(path.Join(directory, blobsDir), 0755); err != nil {
+		return nil, err
+	}
+
+	s.contentAddressed = true
+
+	return s, nil
 }
 
 func (s *Storage) GetGPGPubKey() ([]byte, error) {
@@ -32,79 +66,603 @@ func (s *Storage) GetGPGPubKey() ([]byte, error) {
 		return []byte{}, storage.ErrGPGPubKeyNotProvided
 	}
 
-	return ioutil.ReadFile(*s.gpgPubKey)
-}
+	buf, err := ioutil.ReadFile(*s.gpgPubKey)
 
-func (s *Storage) ListACIs() ([]aci.Aci, error) {
-	files, err := ioutil.ReadDir(s.directory)
 	if err != nil {
-		return nil, err
+		return []byte{}, translateError(err)
 	}
 
+	return buf, nil
+}
+
+func (s *Storage) ListACIs() ([]aci.Aci, []aci.InvalidFile, error) {
 	res := []aci.RawFile{}
-	for _, file := range files {
-		res = append(
-			res,
-			aci.RawFile{file.Name(), file.ModTime()},
-		)
+	blobs, err := s.blobInodes()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := filepath.Walk(
+		s.directory,
+		func(p string, file os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			rel, err := filepath.Rel(s.directory, p)
+
+			if err != nil || rel == "." {
+				return err
+			}
+
+			rel = filepath.ToSlash(rel)
+
+			if file.IsDir() {
+				if rel == "tmp" || strings.HasPrefix(file.Name(), ".") {
+					return filepath.SkipDir
+				}
+
+				return nil
+			}
+
+			if !file.Mode().IsRegular() || strings.HasPrefix(file.Name(), ".") {
+				return nil
+			}
+
+			f := aci.RawFile{Name: rel, Date: file.ModTime(), Size: file.Size()}
+
+			if ino, ok := inode(file); ok {
+				f.Digest = blobs[ino]
+			}
+
+			// The digests of the ACIs not linked to blobs are read from
+			// their sidecars.
+			if strings.HasSuffix(rel, aci.ManifestExt) || strings.HasSuffix(rel, aci.DigestExt) {
+				if f.Content, err = ioutil.ReadFile(p); err != nil {
+					return err
+				}
+			}
+
+			res = append(res, f)
+
+			return nil
+		},
+	); err != nil {
+		return nil, nil, translateError(err)
 	}
 
-	return aci.BuildAciList(res), nil
+	acis, invalid := aci.BuildAciList(res)
+
+	return acis, invalid, nil
+}
+
+func (s *Storage) tmpPath(up upload.Upload, ext string) string {
+	return path.Join(s.directory, "tmp", strconv.Itoa(int(up.ID))+ext)
 }
 
 func (s *Storage) upload(path string, reader io.Reader) error {
 	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 
 	if err != nil {
-		return err
+		return translateError(err)
 	}
 
 	defer f.Close()
 
 	_, err = io.Copy(f, reader)
 
-	return err
+	return translateError(err)
 }
 
 func (s *Storage) UploadACI(up upload.Upload, reader io.Reader) error {
-	return s.upload(
-		path.Join(s.directory, "tmp", strconv.Itoa(int(up.ID))),
-		reader,
-	)
+	return s.upload(s.tmpPath(up, ""), reader)
 }
 
 func (s *Storage) UploadASC(up upload.Upload, reader io.Reader) error {
-	return s.upload(
-		path.Join(s.directory, "tmp", strconv.Itoa(int(up.ID))+".asc"),
-		reader,
-	)
+	return s.upload(s.tmpPath(up, aci.SignatureExt), reader)
+}
+
+func (s *Storage) UploadManifest(up upload.Upload, reader io.Reader) error {
+	return s.upload(s.tmpPath(up, aci.ManifestExt), reader)
 }
 
 func (s *Storage) CancelUpload(up upload.Upload) error {
-	os.Remove(path.Join(s.directory, "tmp", strconv.Itoa(int(up.ID))+".asc"))
-	os.Remove(path.Join(s.directory, "tmp", strconv.Itoa(int(up.ID))))
+	for _, ext := range []string{"", aci.SignatureExt, aci.ManifestExt, aci.DigestExt} {
+		os.Remove(s.tmpPath(up, ext))
+	}
 
 	return nil
 }
 
 func (s *Storage) FinishUpload(up upload.Upload) error {
-	if err := os.Rename(
-		path.Join(s.directory, "tmp", strconv.Itoa(int(up.ID))),
-		path.Join(s.directory, up.Image),
+	if !storage.ValidName(up.Image) {
+		return storage.ErrInvalidName
+	}
+
+	if err := os.MkdirAll(
+		path.Dir(path.Join(s.directory, up.Image)),
+		0755,
 	); err != nil {
+		return translateError(err)
+	}
+
+	if err := s.publishACI(up); err != nil {
 		return err
 	}
 
 	if err := os.Rename(
-		path.Join(s.directory, "tmp", strconv.Itoa(int(up.ID))+".asc"),
-		path.Join(s.directory, up.Image+".asc"),
+		s.tmpPath(up, aci.SignatureExt),
+		path.Join(s.directory, up.Image+aci.SignatureExt),
+	); err != nil {
+		return translateError(err)
+	}
+
+	if err := os.Rename(
+		s.tmpPath(up, aci.ManifestExt),
+		path.Join(s.directory, up.Image+aci.ManifestExt),
+	); err != nil && !os.IsNotExist(err) {
+		return translateError(err)
+	}
+
+	digestPath := path.Join(s.directory, up.Image+aci.DigestExt)
+
+	if up.Digest == "" {
+		os.Remove(digestPath)
+	} else if err := s.upload(
+		digestPath,
+		strings.NewReader(up.Digest),
 	); err != nil {
 		return err
 	}
 
+	os.Remove(path.Join(s.directory, up.Image+aci.YankedExt))
+
+	return nil
+}
+
+// publishACI moves the ACI of an upload under its name or, in the content
+// addressed layout, links the name to the blob of its digest, only stored if
+// it is new.
+func (s *Storage) publishACI(up upload.Upload) error {
+	tmp := s.tmpPath(up, "")
+
+	if !s.contentAddressed || !storage.ValidDigest(up.Digest) {
+		return translateError(
+			os.Rename(tmp, path.Join(s.directory, up.Image)),
+		)
+	}
+
+	blob := path.Join(blobsDir, up.Digest)
+
+	// The blob may be collected between being found and linked, it is
+	// stored again then.
+	for i := 0; ; i++ {
+		err := os.Link(tmp, path.Join(s.directory, blob))
+
+		// Refreshed so that the collections in progress keep it.
+		if os.IsExist(err) {
+			now := time.Now()
+			err = os.Chtimes(path.Join(s.directory, blob), now, now)
+
+			if os.IsNotExist(err) {
+				err = nil
+			}
+		}
+
+		if err != nil {
+			return translateError(err)
+		}
+
+		err = s.link(blob, up.Image)
+
+		if err == storage.ErrNotFound && i == 0 {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		return translateError(os.Remove(tmp))
+	}
+}
+
+func (s *Storage) DeleteACI(n string) error {
+	if !storage.ValidName(n) {
+		return storage.ErrInvalidName
+	}
+
+	if err := os.Remove(path.Join(s.directory, n)); err != nil {
+		return translateError(err)
+	}
+
+	for _, ext := range []string{
+		aci.SignatureExt,
+		aci.ManifestExt,
+		aci.DigestExt,
+		aci.YankedExt,
+	} {
+		if err := os.Remove(
+			path.Join(s.directory, n+ext),
+		); err != nil && !os.IsNotExist(err) {
+			return translateError(err)
+		}
+	}
+
+	s.removeEmptyParents(n)
+
+	return nil
+}
+
+func (s *Storage) removeEmptyParents(n string) {
+	for dir := path.Dir(n); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if os.Remove(path.Join(s.directory, dir)) != nil {
+			return
+		}
+	}
+}
+
+func (s *Storage) YankACI(n string, yanked bool) error {
+	if !storage.ValidName(n) {
+		return storage.ErrInvalidName
+	}
+
+	if _, err := os.Stat(path.Join(s.directory, n)); err != nil {
+		return translateError(err)
+	}
+
+	if !yanked {
+		if err := os.Remove(
+			path.Join(s.directory, n+aci.YankedExt),
+		); err != nil && !os.IsNotExist(err) {
+			return translateError(err)
+		}
+
+		return nil
+	}
+
+	return s.upload(path.Join(s.directory, n+aci.YankedExt), &bytes.Buffer{})
+}
+
+func (s *Storage) TagACI(src, dst string) error {
+	if !storage.ValidName(src) || !storage.ValidName(dst) {
+		return storage.ErrInvalidName
+	}
+
+	if err := os.MkdirAll(
+		path.Dir(path.Join(s.directory, dst)),
+		0755,
+	); err != nil {
+		return translateError(err)
+	}
+
+	for _, ext := range []string{"", aci.SignatureExt} {
+		if err := s.link(src+ext, dst+ext); err != nil {
+			return err
+		}
+	}
+
+	for _, ext := range []string{aci.ManifestExt, aci.DigestExt} {
+		err := s.link(src+ext, dst+ext)
+
+		if err == storage.ErrNotFound {
+			err = translateError(os.Remove(path.Join(s.directory, dst+ext)))
+		}
+
+		if err != nil && err != storage.ErrNotFound {
+			return err
+		}
+	}
+
+	os.Remove(path.Join(s.directory, dst+aci.YankedExt))
+
 	return nil
 }
 
-func (s *Storage) DownloadACI(n string) (io.ReadSeeker, error) {
-	return os.Open(path.Join(s.directory, n))
+// link hard links a file under a new name, replacing any existing one
+// atomically.
+func (s *Storage) link(src, dst string) error {
+	f, err := ioutil.TempFile(path.Join(s.directory, "tmp"), "link")
+
+	if err != nil {
+		return translateError(err)
+	}
+
+	f.Close()
+	os.Remove(f.Name())
+
+	if err := os.Link(path.Join(s.directory, src), f.Name()); err != nil {
+		return translateError(err)
+	}
+
+	if err := os.Rename(f.Name(), path.Join(s.directory, dst)); err != nil {
+		os.Remove(f.Name())
+		return translateError(err)
+	}
+
+	return nil
+}
+
+func (s *Storage) ListBlobs() ([]storage.Blob, error) {
+	if !s.contentAddressed {
+		return nil, storage.ErrNotSupported
+	}
+
+	files, err := ioutil.ReadDir(path.Join(s.directory, blobsDir))
+
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	res := []storage.Blob{}
+
+	for _, f := range files {
+		if !storage.ValidDigest(f.Name()) {
+			continue
+		}
+
+		res = append(
+			res,
+			storage.Blob{
+				Digest:   f.Name(),
+				Size:     f.Size(),
+				Refs:     refs(f),
+				Modified: f.ModTime(),
+			},
+		)
+	}
+
+	return res, nil
+}
+
+func (s *Storage) DeleteBlob(blob storage.Blob) error {
+	if !s.contentAddressed {
+		return storage.ErrNotSupported
+	}
+
+	if !storage.ValidDigest(blob.Digest) {
+		return storage.ErrInvalidName
+	}
+
+	p := path.Join(s.directory, blobsDir, blob.Digest)
+	fi, err := os.Stat(p)
+
+	if err != nil {
+		return translateError(err)
+	}
+
+	if refs(fi) > 0 || fi.ModTime().After(blob.Modified) {
+		return storage.ErrConflict
+	}
+
+	// Names linked meanwhile keep the content anyway.
+	return translateError(os.Remove(p))
+}
+
+func (s *Storage) DownloadBlob(digest string) (storage.ReadSeekCloser, error) {
+	if !s.contentAddressed {
+		return nil, storage.ErrNotSupported
+	}
+
+	if !storage.ValidDigest(digest) {
+		return nil, storage.ErrInvalidName
+	}
+
+	f, err := os.Open(path.Join(s.directory, blobsDir, digest))
+
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return f, nil
+}
+
+// IndexesDigests is always true, the listings know the digests of the names
+// linked to the blobs and read the ones of the others from their sidecars.
+func (s *Storage) IndexesDigests() bool {
+	return true
+}
+
+// blobInodes returns the digests of the blobs by inode, none unless the
+// storage is content addressed.
+func (s *Storage) blobInodes() (map[uint64]string, error) {
+	res := map[uint64]string{}
+
+	if !s.contentAddressed {
+		return res, nil
+	}
+
+	files, err := ioutil.ReadDir(path.Join(s.directory, blobsDir))
+
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	for _, f := range files {
+		if ino, ok := inode(f); ok && storage.ValidDigest(f.Name()) {
+			res[ino] = f.Name()
+		}
+	}
+
+	return res, nil
+}
+
+func inode(fi os.FileInfo) (uint64, bool) {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino), true
+	}
+
+	return 0, false
+}
+
+// refs returns the number of names linked to a blob, blobs are considered
+// referenced when the link count is unknown.
+func refs(fi os.FileInfo) int {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return int(st.Nlink) - 1
+	}
+
+	return 1
+}
+
+func (s *Storage) metadataPath(key string) string {
+	return path.Join(s.directory, metadataDir, key)
+}
+
+func (s *Storage) GetMetadata(key string) ([]byte, error) {
+	if !storage.ValidName(key) {
+		return nil, storage.ErrInvalidName
+	}
+
+	buf, err := ioutil.ReadFile(s.metadataPath(key))
+
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return buf, nil
+}
+
+func (s *Storage) PutMetadata(key string, blob []byte) error {
+	if !storage.ValidName(key) {
+		return storage.ErrInvalidName
+	}
+
+	p := s.metadataPath(key)
+
+	if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+		return translateError(err)
+	}
+
+	f, err := ioutil.TempFile(path.Join(s.directory, "tmp"), "metadata")
+
+	if err != nil {
+		return translateError(err)
+	}
+
+	_, err = f.Write(blob)
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = os.Rename(f.Name(), p)
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+	}
+
+	return translateError(err)
+}
+
+func (s *Storage) ListMetadata(prefix string) ([]string, error) {
+	root := path.Join(s.directory, metadataDir)
+	res := []string{}
+
+	if err := filepath.Walk(
+		root,
+		func(p string, file os.FileInfo, err error) error {
+			if os.IsNotExist(err) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+
+			if file.IsDir() {
+				return nil
+			}
+
+			key, err := filepath.Rel(root, p)
+
+			if err != nil {
+				return err
+			}
+
+			if key = filepath.ToSlash(key); strings.HasPrefix(key, prefix) {
+				res = append(res, key)
+			}
+
+			return nil
+		},
+	); err != nil {
+		return nil, translateError(err)
+	}
+
+	return res, nil
+}
+
+func (s *Storage) StatACI(n string) (*storage.ObjectInfo, error) {
+	if !storage.ValidName(n) {
+		return nil, storage.ErrInvalidName
+	}
+
+	fi, err := os.Stat(path.Join(s.directory, n))
+
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	if fi.IsDir() {
+		return nil, storage.ErrNotFound
+	}
+
+	digest, err := ioutil.ReadFile(path.Join(s.directory, n+aci.DigestExt))
+
+	if err != nil && !os.IsNotExist(err) {
+		return nil, translateError(err)
+	}
+
+	return &storage.ObjectInfo{
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+		Digest:  strings.TrimSpace(string(digest)),
+	}, nil
+}
+
+func (s *Storage) DownloadACI(n string) (storage.ReadSeekCloser, error) {
+	if !storage.ValidName(n) {
+		return nil, storage.ErrInvalidName
+	}
+
+	f, err := os.Open(path.Join(s.directory, n))
+
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return f, nil
+}
+
+func translateError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case os.IsNotExist(err):
+		return storage.ErrNotFound
+	case os.IsExist(err):
+		return storage.ErrConflict
+	}
+
+	var errno error
+
+	switch e := err.(type) {
+	case *os.PathError:
+		errno = e.Err
+	case *os.LinkError:
+		errno = e.Err
+	case *os.SyscallError:
+		errno = e.Err
+	}
+
+	switch errno {
+	case syscall.ENOSPC:
+		return storage.ErrQuotaExceeded
+	case syscall.EISDIR, syscall.ENOTDIR:
+		return storage.ErrConflict
+	case syscall.ENAMETOOLONG:
+		return storage.ErrInvalidName
+	}
+
+	return err
 }
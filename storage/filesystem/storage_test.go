@@ -0,0 +1,215 @@
+package filesystem
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appc/acserver/storage"
+	"github.com/appc/acserver/upload"
+)
+
+func newTestStorage(t *testing.T) (*Storage, func()) {
+	dir, err := ioutil.TempDir("", "acserver-filesystem")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewStorage(dir, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s, func() { os.RemoveAll(dir) }
+}
+
+func publish(t *testing.T, s *Storage, id uint64, name, digest string) {
+	up := upload.Upload{ID: id, Image: name, Digest: digest}
+
+	for _, err := range []error{
+		s.UploadACI(up, strings.NewReader("aci")),
+		s.UploadASC(up, strings.NewReader("asc")),
+		s.FinishUpload(up),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTagACI(t *testing.T) {
+	s, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	src := "example.com/app-1.4.2-linux-amd64.aci"
+	dst := "example.com/app-stable-linux-amd64.aci"
+	publish(t, s, 1, src, "sha512-aci")
+
+	for _, err := range []error{
+		ioutil.WriteFile(path.Join(s.directory, dst+".manifest"), []byte("stale"), 0644),
+		s.TagACI(src, dst),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for ext, content := range map[string]string{"": "aci", ".asc": "asc"} {
+		if buf, err := ioutil.ReadFile(path.Join(s.directory, dst+ext)); err != nil || string(buf) != content {
+			t.Errorf("Wrong %q link: %q %v", ext, buf, err)
+		}
+	}
+
+	if _, err := os.Stat(path.Join(s.directory, dst+".manifest")); !os.IsNotExist(err) {
+		t.Errorf("Stale manifest kept: %v", err)
+	}
+
+	srcInfo, _ := s.StatACI(src)
+	dstInfo, _ := s.StatACI(dst)
+
+	if srcInfo.Digest == "" || srcInfo.Digest != dstInfo.Digest {
+		t.Errorf("Wrong digest: %q %q", srcInfo.Digest, dstInfo.Digest)
+	}
+
+	if err := s.TagACI("example.com/app-0.0.0-linux-amd64.aci", dst); err != storage.ErrNotFound {
+		t.Errorf("Wrong error tagging a missing image: %v", err)
+	}
+}
+
+func TestContentAddressed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acserver-filesystem")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	s, err := NewContentAddressedStorage(dir, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest := "sha512-" + strings.Repeat("ab", 64)
+	names := []string{
+		"example.com/app-1.0.0-linux-amd64.aci",
+		"example.com/copy-1.0.0-linux-amd64.aci",
+	}
+
+	for i, name := range names {
+		// Pushes of the same content refresh the blob.
+		if i > 0 {
+			old := time.Now().Add(-time.Hour)
+
+			if err := os.Chtimes(path.Join(dir, blobsDir, digest), old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		publish(t, s, uint64(i), name, digest)
+	}
+
+	blobs, err := s.ListBlobs()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(blobs) != 1 || blobs[0].Digest != digest || blobs[0].Size != 3 || blobs[0].Refs != 2 {
+		t.Fatalf("Wrong blobs: %+v", blobs)
+	}
+
+	if time.Since(blobs[0].Modified) > time.Minute {
+		t.Errorf("Blob not refreshed: %v", blobs[0].Modified)
+	}
+
+	if info, err := s.StatACI(names[1]); err != nil || info.Digest != digest {
+		t.Errorf("Wrong info: %+v %v", info, err)
+	}
+
+	if err := s.DeleteBlob(blobs[0]); err != storage.ErrConflict {
+		t.Errorf("Wrong error deleting a referenced blob: %v", err)
+	}
+
+	for _, name := range names {
+		if err := s.DeleteACI(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if collected, err := storage.CollectBlobs(s, false, time.Now()); err != nil || len(collected) != 0 {
+		t.Errorf("Blobs collected within the grace period: %+v %v", collected, err)
+	}
+
+	for _, dryRun := range []bool{true, false} {
+		collected, err := storage.CollectBlobs(s, dryRun, time.Now().Add(storage.BlobGrace))
+
+		if err != nil || len(collected) != 1 || collected[0].Digest != digest {
+			t.Errorf("dry run %v: wrong collected blobs: %+v %v", dryRun, collected, err)
+		}
+	}
+
+	if blobs, err := s.ListBlobs(); err != nil || len(blobs) != 0 {
+		t.Errorf("Blobs kept: %+v %v", blobs, err)
+	}
+}
+
+func TestFindDigest(t *testing.T) {
+	plain, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	dir, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	ca, err := NewContentAddressedStorage(dir.directory, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest := "sha512-" + strings.Repeat("ab", 64)
+
+	// The plain layout reads the digests from the sidecars, the content
+	// addressed one from the blobs.
+	for _, s := range []*Storage{plain, ca} {
+		publish(t, s, 0, "example.com/app-1.0.0-linux-amd64.aci", digest)
+		publish(t, s, 1, "example.com/app-2.0.0-linux-amd64.aci", "sha512-"+strings.Repeat("cd", 64))
+
+		if err := s.TagACI("example.com/app-1.0.0-linux-amd64.aci", "example.com/app-stable-linux-amd64.aci"); err != nil {
+			t.Fatal(err)
+		}
+
+		refs, err := storage.FindDigest(s, digest)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		files := []string{}
+
+		for _, r := range refs {
+			files = append(files, r.File)
+		}
+
+		sort.Strings(files)
+
+		if strings.Join(files, " ") != "example.com/app-1.0.0-linux-amd64.aci example.com/app-stable-linux-amd64.aci" {
+			t.Errorf("Wrong references: %+v", refs)
+		}
+
+		if _, err := storage.FindDigest(s, "sha512-"+strings.Repeat("ef", 64)); err != storage.ErrNotFound {
+			t.Errorf("Wrong error for an unknown digest: %v", err)
+		}
+
+		if _, err := storage.FindDigest(s, "sha512-aci"); err != storage.ErrInvalidName {
+			t.Errorf("Wrong error for an invalid digest: %v", err)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package storage
+
+import "github.com/appc/acserver/aci"
+
+// Reference is a file of an image holding the ACI of a digest.
+type Reference struct {
+	Name string `json:"name"`
+	aci.AciDetails
+}
+
+// DigestIndex is implemented by the storages whose listings may hold the
+// digests of the ACIs, the decorators tell the ones of the storages they
+// wrap.
+type DigestIndex interface {
+	// IndexesDigests reports whether the listings hold the digests.
+	IndexesDigests() bool
+}
+
+// FindDigest returns the files holding the ACI of a digest, whatever name
+// or tag they are published as. It fails with ErrNotSupported unless the
+// listings hold the digests, reading every ACI instead wouldn't scale.
+func FindDigest(s Storage, digest string) ([]Reference, error) {
+	if !ValidDigest(digest) {
+		return nil, ErrInvalidName
+	}
+
+	if i, ok := s.(DigestIndex); !ok || !i.IndexesDigests() {
+		return nil, ErrNotSupported
+	}
+
+	acis, _, err := s.ListACIs()
+
+	if err != nil {
+		return nil, err
+	}
+
+	res := []Reference{}
+
+	for _, a := range acis {
+		for _, d := range a.Details {
+			if d.Digest == digest {
+				res = append(res, Reference{Name: a.Name, AciDetails: d})
+			}
+		}
+	}
+
+	if len(res) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return res, nil
+}
@@ -0,0 +1,80 @@
+package s3
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// partBuffer holds a single part of an upload before it is sent to S3, so
+// that a push never keeps more than one part in memory or on disk.
+type partBuffer interface {
+	io.Writer
+	Reader() io.ReadSeeker
+	Reset() error
+	Close() error
+}
+
+// memoryBuffer grows as the part is written, small bodies like manifests and
+// signatures don't allocate a whole part.
+type memoryBuffer struct {
+	bytes.Buffer
+}
+
+func (b *memoryBuffer) Reader() io.ReadSeeker {
+	return bytes.NewReader(b.Bytes())
+}
+
+func (b *memoryBuffer) Reset() error {
+	b.Buffer.Reset()
+	return nil
+}
+
+func (b *memoryBuffer) Close() error {
+	return nil
+}
+
+type fileBuffer struct {
+	file *os.File
+	size int64
+}
+
+func newFileBuffer(dir string) (*fileBuffer, error) {
+	f, err := ioutil.TempFile(dir, "acserver-part-")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileBuffer{file: f}, nil
+}
+
+func (b *fileBuffer) Write(p []byte) (int, error) {
+	n, err := b.file.Write(p)
+	b.size += int64(n)
+
+	return n, err
+}
+
+func (b *fileBuffer) Reader() io.ReadSeeker {
+	return io.NewSectionReader(b.file, 0, b.size)
+}
+
+func (b *fileBuffer) Reset() error {
+	if err := b.file.Truncate(0); err != nil {
+		return err
+	}
+
+	b.size = 0
+	_, err := b.file.Seek(0, os.SEEK_SET)
+
+	return err
+}
+
+func (b *fileBuffer) Close() error {
+	err := b.file.Close()
+	os.Remove(b.file.Name())
+
+	return err
+}
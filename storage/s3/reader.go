@@ -0,0 +1,125 @@
+package s3
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/appc/acserver/Godeps/_workspace/src/github.com/upfluence/goamz/s3"
+)
+
+const rangeURLExpiry = 15 * time.Minute
+
+var errInvalidOffset = errors.New("s3: invalid offset")
+
+// reader streams an object from the bucket. Reading starts a GET at the
+// current offset, seeking drops the pending response so that the next read
+// issues a ranged GET from the new offset.
+type reader struct {
+	bucket *s3.Bucket
+	path   string
+	size   int64
+
+	offset int64
+	body   io.ReadCloser
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+
+	if r.body == nil {
+		body, err := r.open()
+
+		if err != nil {
+			return 0, err
+		}
+
+		r.body = body
+	}
+
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+
+	if err == io.EOF && r.offset < r.size {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return n, err
+}
+
+func (r *reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case os.SEEK_SET:
+	case os.SEEK_CUR:
+		offset += r.offset
+	case os.SEEK_END:
+		offset += r.size
+	default:
+		return r.offset, errInvalidOffset
+	}
+
+	if offset < 0 {
+		return r.offset, errInvalidOffset
+	}
+
+	if offset != r.offset {
+		r.Close()
+		r.offset = offset
+	}
+
+	return r.offset, nil
+}
+
+func (r *reader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+
+	err := r.body.Close()
+	r.body = nil
+
+	return err
+}
+
+func (r *reader) open() (io.ReadCloser, error) {
+	if r.offset == 0 {
+		body, err := r.bucket.GetReader(r.path)
+
+		return body, translateError(err)
+	}
+
+	u, err := r.bucket.SignedURL(r.path, time.Now().Add(rangeURLExpiry))
+
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("GET", u, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", r.offset))
+
+	resp, err := r.bucket.HTTPClient().Do(req)
+
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	if resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+
+		return nil, translateError(
+			&s3.Error{StatusCode: resp.StatusCode, Message: resp.Status},
+		)
+	}
+
+	return resp.Body, nil
+}
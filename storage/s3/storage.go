@@ -1,87 +1,507 @@
 package s3
 
 import (
-	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/appc/acserver/Godeps/_workspace/src/github.com/upfluence/goamz/aws"
 	"github.com/appc/acserver/Godeps/_workspace/src/github.com/upfluence/goamz/s3"
 	"github.com/appc/acserver/aci"
+	"github.com/appc/acserver/storage"
 	"github.com/appc/acserver/upload"
 )
 
 const (
 	gpgPubKeyPath = "keys/key.pub"
 	aciPath       = "acis/"
+	metadataPath  = "metadata/"
+	blobPath      = "blobs/"
+	// refPath holds an empty refs/<name>/<digest> object for each name of
+	// the content addressed layout, so that listings see the blobs they
+	// reference without reading them.
+	refPath = "refs/"
+
+	// maxRefSize bounds the size of the references of the content
+	// addressed layout, larger objects are ACIs.
+	maxRefSize = 1024
+
+	DefaultPartSize = 16 << 20
+	MinPartSize     = 5 << 20
 )
 
+type Options struct {
+	// PartSize is the amount of data buffered before being sent to S3,
+	// bodies larger than this are uploaded as multiple parts. S3 requires
+	// every part but the last one to be at least MinPartSize, it defaults
+	// to DefaultPartSize.
+	PartSize int64
+
+	// BufferDir, when set, makes uploads buffer their parts in temporary
+	// files of this directory instead of memory.
+	BufferDir string
+
+	// PresignExpiry, when set, makes downloads redirect to presigned URLs
+	// valid for this duration instead of being proxied.
+	PresignExpiry time.Duration
+
+	// ContentAddressed stores every ACI once under its digest, the objects
+	// of their names only reference it.
+	ContentAddressed bool
+}
+
+// ref is the content of the names of the content addressed layout.
+type ref struct {
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+}
+
+func parseRef(blob []byte) (*ref, bool) {
+	r := &ref{}
+
+	if err := json.Unmarshal(blob, r); err != nil || !storage.ValidDigest(r.Digest) {
+		return nil, false
+	}
+
+	return r, true
+}
+
+func isSidecar(n string) bool {
+	for _, ext := range []string{
+		aci.SignatureExt,
+		aci.ManifestExt,
+		aci.DigestExt,
+		aci.YankedExt,
+	} {
+		if strings.HasSuffix(n, ext) {
+			return true
+		}
+	}
+
+	return false
+}
+
 type Storage struct {
 	*s3.Bucket
+
+	opts Options
+
+	// sidecars caches the content of the manifests and digests by key, along
+	// with the ETag it was read at, so that listings don't read them again.
+	mu       sync.Mutex
+	sidecars map[string]cachedSidecar
 }
 
-func NewStorage(auth aws.Auth, region aws.Region, bucket string) (*Storage, error) {
-	return &Storage{s3.New(auth, region).Bucket(bucket)}, nil
+type cachedSidecar struct {
+	etag    string
+	content []byte
+}
+
+func NewStorage(auth aws.Auth, region aws.Region, bucket string, opts Options) (*Storage, error) {
+	if opts.PartSize == 0 {
+		opts.PartSize = DefaultPartSize
+	}
+
+	if opts.PartSize < MinPartSize {
+		return nil, fmt.Errorf(
+			"s3: part size %d below the %d bytes minimum",
+			opts.PartSize,
+			MinPartSize,
+		)
+	}
+
+	return &Storage{
+		Bucket:   s3.New(auth, region).Bucket(bucket),
+		opts:     opts,
+		sidecars: map[string]cachedSidecar{},
+	}, nil
 }
 
 func (s *Storage) GetGPGPubKey() ([]byte, error) {
-	return s.Get(gpgPubKeyPath)
+	buf, err := s.Get(gpgPubKeyPath)
+
+	if err := translateError(err); err == storage.ErrNotFound {
+		return []byte{}, storage.ErrGPGPubKeyNotProvided
+	} else if err != nil {
+		return []byte{}, err
+	}
+
+	return buf, nil
 }
 
-func (s *Storage) ListACIs() ([]aci.Aci, error) {
+func (s *Storage) ListACIs() ([]aci.Aci, []aci.InvalidFile, error) {
+	keys, err := s.listKeys(aciPath)
+
+	if err != nil {
+		return []aci.Aci{}, []aci.InvalidFile{}, err
+	}
+
+	refs, sizes, err := s.listSizes()
+
+	if err != nil {
+		return []aci.Aci{}, []aci.InvalidFile{}, err
+	}
+
 	res := []aci.RawFile{}
-	r, err := s.List(aciPath, "/", "", 0)
+	sidecars := map[string]bool{}
+
+	for _, k := range keys {
+		t, _ := time.Parse(time.RFC3339, k.LastModified)
+		f := aci.RawFile{
+			Name: strings.TrimPrefix(k.Key, aciPath),
+			Date: t,
+			Size: k.Size,
+		}
+
+		// Manifests help splitting the names and versions containing
+		// dashes, as the ones of tags, and digests tell the ones of the
+		// ACIs not referencing blobs.
+		digest := strings.HasSuffix(f.Name, aci.DigestExt) &&
+			len(refs[strings.TrimSuffix(f.Name, aci.DigestExt)]) == 0
+
+		if strings.HasSuffix(k.Key, aci.ManifestExt) || digest {
+			if f.Content, err = s.sidecar(k); err != nil {
+				return []aci.Aci{}, []aci.InvalidFile{}, err
+			}
+
+			sidecars[k.Key] = true
+		}
+
+		if digests := refs[f.Name]; len(digests) > 0 && !isSidecar(f.Name) {
+			f.Size, f.Digest = sizes[digests[0]], digests[0]
+		}
+
+		res = append(res, f)
+	}
+
+	s.mu.Lock()
+
+	for k := range s.sidecars {
+		if !sidecars[k] {
+			delete(s.sidecars, k)
+		}
+	}
+
+	s.mu.Unlock()
+
+	acis, invalid := aci.BuildAciList(res)
+
+	return acis, invalid, nil
+}
+
+// sidecar returns the content of a listed manifest or digest, only reading
+// it again when its ETag changed.
+func (s *Storage) sidecar(k s3.Key) ([]byte, error) {
+	s.mu.Lock()
+	c, ok := s.sidecars[k.Key]
+	s.mu.Unlock()
+
+	if ok && c.etag == k.ETag {
+		return c.content, nil
+	}
+
+	blob, err := s.Get(k.Key)
+
+	// Deleted since listed.
+	if err := translateError(err); err == storage.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.sidecars[k.Key] = cachedSidecar{k.ETag, blob}
+	s.mu.Unlock()
+
+	return blob, nil
+}
+
+// listKeys returns every key starting with a prefix.
+func (s *Storage) listKeys(prefix string) ([]s3.Key, error) {
+	res := []s3.Key{}
+	marker := ""
+
+	for {
+		r, err := s.List(prefix, "", marker, 0)
+
+		if err != nil {
+			return nil, translateError(err)
+		}
+
+		res = append(res, r.Contents...)
+
+		if !r.IsTruncated || len(r.Contents) == 0 {
+			break
+		}
+
+		marker = r.NextMarker
+
+		if marker == "" {
+			marker = r.Contents[len(r.Contents)-1].Key
+		}
+	}
+
+	return res, nil
+}
+
+// listRefs returns the digests of the blobs the names starting with a prefix
+// reference, usually one per name.
+func (s *Storage) listRefs(prefix string) (map[string][]string, error) {
+	res := map[string][]string{}
+
+	if !s.opts.ContentAddressed {
+		return res, nil
+	}
+
+	keys, err := s.listKeys(refPath + prefix)
 
 	if err != nil {
-		return []aci.Aci{}, err
+		return nil, err
 	}
 
-	for _, c := range r.Contents {
-		t, _ := time.Parse(time.RFC3339, c.LastModified)
-		res = append(
-			res,
-			aci.RawFile{strings.TrimPrefix(c.Key, aciPath), t},
-		)
+	for _, k := range keys {
+		n, digest := path.Split(strings.TrimPrefix(k.Key, refPath))
+		n = strings.TrimSuffix(n, "/")
+
+		if storage.ValidDigest(digest) {
+			res[n] = append(res[n], digest)
+		}
 	}
 
-	return aci.BuildAciList(res), nil
+	return res, nil
 }
 
-func (s *Storage) upload(path string, reader io.Reader) error {
-	buf := &bytes.Buffer{}
-	buf.ReadFrom(reader)
-
-	return s.PutReader(
-		path,
-		buf,
-		int64(buf.Len()),
-		"application/octet-stream",
-		s3.Private,
+// listSizes returns the digests referenced by each name and the size of the
+// blobs of the content addressed layout.
+func (s *Storage) listSizes() (map[string][]string, map[string]int64, error) {
+	refs, err := s.listRefs("")
+
+	if err != nil || len(refs) == 0 {
+		return refs, nil, err
+	}
+
+	keys, err := s.listKeys(blobPath)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sizes := map[string]int64{}
+
+	for _, k := range keys {
+		sizes[strings.TrimPrefix(k.Key, blobPath)] = k.Size
+	}
+
+	return refs, sizes, nil
+}
+
+// setRef makes a name reference a blob, dropping the references it had, or
+// no blob if digest is empty.
+func (s *Storage) setRef(n, digest string) error {
+	if !s.opts.ContentAddressed {
+		return nil
+	}
+
+	if digest != "" {
+		if err := s.Put(
+			refPath+n+"/"+digest,
+			[]byte{},
+			"application/octet-stream",
+			s3.Private,
+		); err != nil {
+			return translateError(err)
+		}
+	}
+
+	refs, err := s.listRefs(n + "/")
+
+	if err != nil {
+		return err
+	}
+
+	stale := []string{}
+
+	for _, d := range refs[n] {
+		if d != digest {
+			stale = append(stale, refPath+n+"/"+d)
+		}
+	}
+
+	if len(stale) == 0 {
+		return nil
+	}
+
+	return translateError(s.MultiDel(stale))
+}
+
+// refresh updates the modification time of a blob by copying it onto itself,
+// so that the collections in progress keep it.
+func (s *Storage) refresh(digest string) error {
+	return translateError(
+		s.PutHeader(
+			blobPath+digest,
+			[]byte{},
+			map[string][]string{
+				"x-amz-copy-source":        {"/" + s.Name + "/" + blobPath + digest},
+				"x-amz-metadata-directive": {"REPLACE"},
+				"Content-Type":             {"application/octet-stream"},
+			},
+			s3.Private,
+		),
 	)
 }
 
+// readRef returns the reference an object of the content addressed layout
+// holds, or nil if it holds the content itself.
+func (s *Storage) readRef(k s3.Key) (*ref, error) {
+	if !s.opts.ContentAddressed || k.Size > maxRefSize || isSidecar(k.Key) {
+		return nil, nil
+	}
+
+	blob, err := s.Get(k.Key)
+
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	r, _ := parseRef(blob)
+
+	return r, nil
+}
+
+// object locates the content of a name, the blob it references in the
+// content addressed layout.
+func (s *Storage) object(n string) (*s3.Key, *ref, error) {
+	k, err := s.GetKey(aciPath + n)
+
+	if err != nil {
+		return nil, nil, translateError(err)
+	}
+
+	k.Key = aciPath + n
+	r, err := s.readRef(*k)
+
+	if err != nil || r == nil {
+		return k, nil, err
+	}
+
+	return &s3.Key{
+		Key:          blobPath + r.Digest,
+		Size:         r.Size,
+		LastModified: k.LastModified,
+	}, r, nil
+}
+
+func tmpPath(up upload.Upload, ext string) string {
+	return fmt.Sprintf("tmp/%d%s", up.ID, ext)
+}
+
+func (s *Storage) newBuffer() (partBuffer, error) {
+	if s.opts.BufferDir != "" {
+		return newFileBuffer(s.opts.BufferDir)
+	}
+
+	return &memoryBuffer{}, nil
+}
+
+func (s *Storage) upload(path string, reader io.Reader) error {
+	buf, err := s.newBuffer()
+
+	if err != nil {
+		return err
+	}
+
+	defer buf.Close()
+
+	n, err := io.CopyN(buf, reader, s.opts.PartSize)
+
+	if err == io.EOF {
+		return translateError(
+			s.PutReader(
+				path,
+				buf.Reader(),
+				n,
+				"application/octet-stream",
+				s3.Private,
+			),
+		)
+	} else if err != nil {
+		return err
+	}
+
+	multi, err := s.InitMulti(path, "application/octet-stream", s3.Private)
+
+	if err != nil {
+		return translateError(err)
+	}
+
+	if err := s.uploadParts(multi, buf, reader); err != nil {
+		multi.Abort()
+		return err
+	}
+
+	return nil
+}
+
+func (s *Storage) uploadParts(multi *s3.Multi, buf partBuffer, reader io.Reader) error {
+	parts := []s3.Part{}
+
+	for i := 1; ; i++ {
+		part, err := multi.PutPart(i, buf.Reader())
+
+		if err != nil {
+			return translateError(err)
+		}
+
+		parts = append(parts, part)
+
+		if err := buf.Reset(); err != nil {
+			return err
+		}
+
+		n, err := io.CopyN(buf, reader, s.opts.PartSize)
+
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		if n == 0 {
+			break
+		}
+	}
+
+	return translateError(multi.Complete(parts))
+}
+
 func (s *Storage) UploadACI(up upload.Upload, reader io.Reader) error {
-	return s.upload(
-		fmt.Sprintf("tmp/%d", up.ID),
-		reader,
-	)
+	return s.upload(tmpPath(up, ""), reader)
 }
 
 func (s *Storage) UploadASC(up upload.Upload, reader io.Reader) error {
-	return s.upload(
-		fmt.Sprintf("tmp/%d.asc", up.ID),
-		reader,
-	)
+	return s.upload(tmpPath(up, aci.SignatureExt), reader)
+}
+
+func (s *Storage) UploadManifest(up upload.Upload, reader io.Reader) error {
+	return s.upload(tmpPath(up, aci.ManifestExt), reader)
 }
 
 func (s *Storage) deleteTemps(up upload.Upload) error {
-	return s.MultiDel(
-		[]string{
-			fmt.Sprintf("tmp/%d", up.ID),
-			fmt.Sprintf("tmp/%d.asc", up.ID),
-		},
+	return translateError(
+		s.MultiDel(
+			[]string{
+				tmpPath(up, ""),
+				tmpPath(up, aci.SignatureExt),
+				tmpPath(up, aci.ManifestExt),
+				tmpPath(up, aci.DigestExt),
+			},
+		),
 	)
 }
 
@@ -90,31 +510,441 @@ func (s *Storage) CancelUpload(up upload.Upload) error {
 }
 
 func (s *Storage) FinishUpload(up upload.Upload) error {
+	if !storage.ValidName(up.Image) {
+		return storage.ErrInvalidName
+	}
+
+	if err := s.publishACI(up); err != nil {
+		return err
+	}
+
 	if err := s.Copy(
-		fmt.Sprintf("tmp/%d", up.ID),
-		fmt.Sprintf("%s%s", aciPath, up.Image),
+		tmpPath(up, aci.SignatureExt),
+		aciPath+up.Image+aci.SignatureExt,
 		s3.Private,
 	); err != nil {
-		return err
+		return translateError(err)
 	}
 
 	if err := s.Copy(
-		fmt.Sprintf("tmp/%d.asc", up.ID),
-		fmt.Sprintf("%s%s.asc", aciPath, up.Image),
+		tmpPath(up, aci.ManifestExt),
+		aciPath+up.Image+aci.ManifestExt,
 		s3.Private,
+	); err != nil && translateError(err) != storage.ErrNotFound {
+		return translateError(err)
+	}
+
+	var err error
+
+	if up.Digest == "" {
+		err = s.Del(aciPath + up.Image + aci.DigestExt)
+	} else {
+		err = s.Put(
+			aciPath+up.Image+aci.DigestExt,
+			[]byte(up.Digest),
+			"text/plain",
+			s3.Private,
+		)
+	}
+
+	if err != nil {
+		return translateError(err)
+	}
+
+	if err := s.Del(aciPath + up.Image + aci.YankedExt); err != nil {
+		return translateError(err)
+	}
+
+	return s.deleteTemps(up)
+}
+
+// publishACI copies the ACI of an upload under its name or, in the content
+// addressed layout, references the blob of its digest from the name, only
+// copying it if it is new. Existing blobs are refreshed first, and stored
+// again if collected before being referenced.
+func (s *Storage) publishACI(up upload.Upload) error {
+	if !s.opts.ContentAddressed || !storage.ValidDigest(up.Digest) {
+		if err := s.Copy(tmpPath(up, ""), aciPath+up.Image, s3.Private); err != nil {
+			return translateError(err)
+		}
+
+		return s.setRef(up.Image, "")
+	}
+
+	k, err := s.GetKey(tmpPath(up, ""))
+
+	if err != nil {
+		return translateError(err)
+	}
+
+	if err := s.storeBlob(up, false); err != nil {
+		return err
+	}
+
+	if err := s.setRef(up.Image, up.Digest); err != nil {
+		return err
+	}
+
+	blob, err := json.Marshal(ref{up.Digest, k.Size})
+
+	if err != nil {
+		return err
+	}
+
+	if err := s.Put(aciPath+up.Image, blob, "application/json", s3.Private); err != nil {
+		return translateError(err)
+	}
+
+	return s.storeBlob(up, true)
+}
+
+// storeBlob copies the ACI of an upload to the blob of its digest if missing,
+// refreshing it otherwise unless checking only.
+func (s *Storage) storeBlob(up upload.Upload, check bool) error {
+	var err error
+
+	if check {
+		_, err = s.GetKey(blobPath + up.Digest)
+		err = translateError(err)
+	} else {
+		err = s.refresh(up.Digest)
+	}
+
+	if err != storage.ErrNotFound {
+		return err
+	}
+
+	return translateError(
+		s.Copy(tmpPath(up, ""), blobPath+up.Digest, s3.Private),
+	)
+}
+
+func (s *Storage) StatACI(n string) (*storage.ObjectInfo, error) {
+	if !storage.ValidName(n) {
+		return nil, storage.ErrInvalidName
+	}
+
+	k, r, err := s.object(n)
+
+	if err != nil {
+		return nil, err
+	}
+
+	t, _ := time.Parse(http.TimeFormat, k.LastModified)
+	info := &storage.ObjectInfo{Size: k.Size, ModTime: t}
+
+	if r != nil {
+		info.Digest = r.Digest
+		return info, nil
+	}
+
+	digest, err := s.Get(aciPath + n + aci.DigestExt)
+
+	if err != nil && translateError(err) != storage.ErrNotFound {
+		return nil, translateError(err)
+	}
+
+	info.Digest = strings.TrimSpace(string(digest))
+
+	return info, nil
+}
+
+func (s *Storage) DownloadACI(n string) (storage.ReadSeekCloser, error) {
+	if !storage.ValidName(n) {
+		return nil, storage.ErrInvalidName
+	}
+
+	k, _, err := s.object(n)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &reader{bucket: s.Bucket, path: k.Key, size: k.Size}, nil
+}
+
+func (s *Storage) PresignURL(n string) (string, error) {
+	if s.opts.PresignExpiry <= 0 {
+		return "", storage.ErrNotSupported
+	}
+
+	if !storage.ValidName(n) {
+		return "", storage.ErrInvalidName
+	}
+
+	key := aciPath + n
+
+	if s.opts.ContentAddressed {
+		k, _, err := s.object(n)
+
+		if err != nil {
+			return "", err
+		}
+
+		key = k.Key
+	}
+
+	return s.SignedURL(key, time.Now().Add(s.opts.PresignExpiry))
+}
+
+func (s *Storage) exists(n string) error {
+	if !storage.ValidName(n) {
+		return storage.ErrInvalidName
+	}
+
+	_, err := s.GetKey(aciPath + n)
+
+	return translateError(err)
+}
+
+func (s *Storage) DeleteACI(n string) error {
+	if err := s.exists(n); err != nil {
+		return err
+	}
+
+	if err := s.MultiDel(
+		[]string{
+			aciPath + n,
+			aciPath + n + aci.SignatureExt,
+			aciPath + n + aci.ManifestExt,
+			aciPath + n + aci.DigestExt,
+			aciPath + n + aci.YankedExt,
+		},
 	); err != nil {
+		return translateError(err)
+	}
+
+	return s.setRef(n, "")
+}
+
+func (s *Storage) YankACI(n string, yanked bool) error {
+	if err := s.exists(n); err != nil {
 		return err
 	}
 
-	return s.deleteTemps(up)
+	if !yanked {
+		return translateError(s.Del(aciPath + n + aci.YankedExt))
+	}
+
+	return translateError(
+		s.Put(
+			aciPath+n+aci.YankedExt,
+			[]byte{},
+			"application/octet-stream",
+			s3.Private,
+		),
+	)
+}
+
+func (s *Storage) TagACI(src, dst string) error {
+	if !storage.ValidName(dst) {
+		return storage.ErrInvalidName
+	}
+
+	if err := s.exists(src); err != nil {
+		return err
+	}
+
+	refs, err := s.listRefs(src + "/")
+
+	if err != nil {
+		return err
+	}
+
+	digest := ""
+
+	if len(refs[src]) > 0 {
+		digest = refs[src][0]
+	}
+
+	if err := s.setRef(dst, digest); err != nil {
+		return err
+	}
+
+	for _, ext := range []string{"", aci.SignatureExt} {
+		if err := s.Copy(
+			aciPath+src+ext,
+			aciPath+dst+ext,
+			s3.Private,
+		); err != nil {
+			return translateError(err)
+		}
+	}
+
+	for _, ext := range []string{aci.ManifestExt, aci.DigestExt} {
+		err := translateError(
+			s.Copy(aciPath+src+ext, aciPath+dst+ext, s3.Private),
+		)
+
+		if err == storage.ErrNotFound {
+			err = translateError(s.Del(aciPath + dst + ext))
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return translateError(s.Del(aciPath + dst + aci.YankedExt))
 }
 
-func (s *Storage) DownloadACI(n string) (io.ReadSeeker, error) {
-	buf, err := s.Get(aciPath + n)
+func (s *Storage) GetMetadata(key string) ([]byte, error) {
+	if !storage.ValidName(key) {
+		return nil, storage.ErrInvalidName
+	}
+
+	buf, err := s.Get(metadataPath + key)
+
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return buf, nil
+}
+
+func (s *Storage) PutMetadata(key string, blob []byte) error {
+	if !storage.ValidName(key) {
+		return storage.ErrInvalidName
+	}
+
+	return translateError(
+		s.Put(metadataPath+key, blob, "application/json", s3.Private),
+	)
+}
+
+func (s *Storage) ListMetadata(prefix string) ([]string, error) {
+	keys, err := s.listKeys(metadataPath + prefix)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return bytes.NewReader(buf), nil
+	res := []string{}
+
+	for _, k := range keys {
+		res = append(res, strings.TrimPrefix(k.Key, metadataPath))
+	}
+
+	return res, nil
+}
+
+// ListBlobs lists the blobs before counting their references, the blobs
+// pushed meanwhile are either counted or refreshed since listed.
+func (s *Storage) ListBlobs() ([]storage.Blob, error) {
+	if !s.opts.ContentAddressed {
+		return nil, storage.ErrNotSupported
+	}
+
+	keys, err := s.listKeys(blobPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	refs, err := s.listRefs("")
+
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{}
+
+	for _, digests := range refs {
+		for _, d := range digests {
+			counts[d]++
+		}
+	}
+
+	res := []storage.Blob{}
+
+	for _, k := range keys {
+		digest := strings.TrimPrefix(k.Key, blobPath)
+
+		if !storage.ValidDigest(digest) {
+			continue
+		}
+
+		t, _ := time.Parse(time.RFC3339, k.LastModified)
+		res = append(
+			res,
+			storage.Blob{
+				Digest:   digest,
+				Size:     k.Size,
+				Refs:     counts[digest],
+				Modified: t,
+			},
+		)
+	}
+
+	return res, nil
+}
+
+func (s *Storage) DownloadBlob(digest string) (storage.ReadSeekCloser, error) {
+	if !s.opts.ContentAddressed {
+		return nil, storage.ErrNotSupported
+	}
+
+	if !storage.ValidDigest(digest) {
+		return nil, storage.ErrInvalidName
+	}
+
+	k, err := s.GetKey(blobPath + digest)
+
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return &reader{bucket: s.Bucket, path: blobPath + digest, size: k.Size}, nil
+}
+
+// IndexesDigests is always true, the listings see the blobs the names
+// reference and read the digests of the others from their sidecars.
+func (s *Storage) IndexesDigests() bool {
+	return true
+}
+
+func (s *Storage) DeleteBlob(blob storage.Blob) error {
+	if !s.opts.ContentAddressed {
+		return storage.ErrNotSupported
+	}
+
+	if !storage.ValidDigest(blob.Digest) {
+		return storage.ErrInvalidName
+	}
+
+	k, err := s.GetKey(blobPath + blob.Digest)
+
+	if err != nil {
+		return translateError(err)
+	}
+
+	if t, _ := time.Parse(time.RFC1123, k.LastModified); t.After(blob.Modified) {
+		return storage.ErrConflict
+	}
+
+	return translateError(s.Del(blobPath + blob.Digest))
+}
+
+func translateError(err error) error {
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case *s3.Error:
+		switch {
+		case e.StatusCode == 404:
+			return storage.ErrNotFound
+		case e.StatusCode == 409:
+			return storage.ErrConflict
+		case e.Code == "KeyTooLongError" || e.Code == "InvalidURI":
+			return storage.ErrInvalidName
+		case e.Code == "EntityTooLarge" || e.Code == "QuotaExceeded":
+			return storage.ErrQuotaExceeded
+		case e.StatusCode >= 500:
+			return storage.ErrUnavailable
+		}
+	case *url.Error, *net.OpError, *net.DNSError:
+		return storage.ErrUnavailable
+	}
+
+	return err
 }
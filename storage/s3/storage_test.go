@@ -0,0 +1,569 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha512"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/appc/acserver/Godeps/_workspace/src/github.com/upfluence/goamz/aws"
+	"github.com/appc/acserver/Godeps/_workspace/src/github.com/upfluence/goamz/s3"
+	"github.com/appc/acserver/Godeps/_workspace/src/github.com/upfluence/goamz/s3/s3test"
+	"github.com/appc/acserver/aci"
+	"github.com/appc/acserver/storage"
+	"github.com/appc/acserver/upload"
+)
+
+const largeObjectSize = 64 << 20
+
+func TestDownloadLargeACI(t *testing.T) {
+	s, _, quit := newTestStorage(t)
+	defer quit()
+
+	name := "example.com/app-1.0.0-linux-amd64.aci"
+	expected := sha512.New()
+	data := io.LimitReader(rand.New(rand.NewSource(42)), largeObjectSize)
+
+	if err := s.upload(aciPath+name, io.TeeReader(data, expected)); err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := s.DownloadACI(name)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rs.Close()
+
+	if size, err := rs.Seek(0, os.SEEK_END); err != nil || size != largeObjectSize {
+		t.Fatalf("Wrong size: %d, %v", size, err)
+	}
+
+	if _, err := rs.Seek(0, os.SEEK_SET); err != nil {
+		t.Fatal(err)
+	}
+
+	var before, after runtime.MemStats
+
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	h := sha512.New()
+	n, err := io.Copy(h, rs)
+
+	runtime.ReadMemStats(&after)
+
+	if err != nil || n != largeObjectSize {
+		t.Fatalf("Wrong download: %d bytes, %v", n, err)
+	}
+
+	if !bytes.Equal(h.Sum(nil), expected.Sum(nil)) {
+		t.Errorf("Downloaded content differs from the uploaded one")
+	}
+
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 1<<20 {
+		t.Errorf("Download allocated %d bytes", alloc)
+	}
+}
+
+func TestDownloadMissingACI(t *testing.T) {
+	s, _, quit := newTestStorage(t)
+	defer quit()
+
+	if _, err := s.DownloadACI("example.com/missing-1.0.0-linux-amd64.aci"); err != storage.ErrNotFound {
+		t.Errorf("Wrong error: %v", err)
+	}
+}
+
+func TestPresignURL(t *testing.T) {
+	s, _, quit := newTestStorage(t)
+	defer quit()
+
+	name := "example.com/app-1.0.0-linux-amd64.aci"
+
+	if _, err := s.PresignURL(name); err != storage.ErrNotSupported {
+		t.Errorf("Wrong error: %v", err)
+	}
+
+	s.opts.PresignExpiry = time.Minute
+	u, err := s.PresignURL(name)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(u, "/"+aciPath+name+"?") || !strings.Contains(u, "Signature=") {
+		t.Errorf("Wrong presigned URL: %s", u)
+	}
+}
+
+func TestListACIsPaginatesNestedNames(t *testing.T) {
+	s, _, quit := newTestStorage(t)
+	defer quit()
+
+	const count = 1005
+
+	for i := 0; i < count; i++ {
+		if err := s.Put(
+			fmt.Sprintf("%sexample.com/team/app-%d-linux-amd64.aci", aciPath, i),
+			[]byte{},
+			"application/octet-stream",
+			s3.Private,
+		); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	acis, _, err := s.ListACIs()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(acis) != 1 || acis[0].Name != "example.com/team/app" {
+		t.Fatalf("Wrong listing: %+v", acis)
+	}
+
+	if l := len(acis[0].Details); l != count {
+		t.Errorf("Wrong number of versions: %d", l)
+	}
+}
+
+func TestContentAddressedReferences(t *testing.T) {
+	s, m, quit := newTestStorage(t)
+	defer quit()
+
+	s.opts.ContentAddressed = true
+	digest := "sha512-" + strings.Repeat("ab", 64)
+	unused := "sha512-" + strings.Repeat("cd", 64)
+	names := []string{
+		"example.com/app-1.0.0-linux-amd64.aci",
+		"example.com/copy-1.0.0-linux-amd64.aci",
+	}
+
+	publish := func(i int, name string) {
+		up := upload.Upload{ID: uint64(i), Image: name, Digest: digest}
+
+		for _, err := range []error{
+			s.UploadACI(up, strings.NewReader("aci")),
+			s.UploadASC(up, strings.NewReader("asc")),
+			s.FinishUpload(up),
+		} {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for i, name := range names {
+		publish(i, name)
+	}
+
+	if err := s.TagACI(names[0], "example.com/app-stable-linux-amd64.aci"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Put(blobPath+unused, []byte("old"), "application/octet-stream", s3.Private); err != nil {
+		t.Fatal(err)
+	}
+
+	if info, err := s.StatACI(names[1]); err != nil || info.Size != 3 || info.Digest != digest {
+		t.Errorf("Wrong info: %+v %v", info, err)
+	}
+
+	download := func(name string) string {
+		r, err := s.DownloadACI(name)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer r.Close()
+
+		buf := &bytes.Buffer{}
+		io.Copy(buf, r)
+
+		return buf.String()
+	}
+
+	if c := download(names[0]); c != "aci" {
+		t.Errorf("Wrong content: %q", c)
+	}
+
+	// Listings see the references without reading them.
+	m.gets = 0
+	acis, _, err := s.ListACIs()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, a := range acis {
+		for _, d := range a.Details {
+			if d.Size != 3 {
+				t.Errorf("%s: wrong size: %d", d.File, d.Size)
+			}
+		}
+	}
+
+	blobs, err := s.ListBlobs()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.gets != 0 {
+		t.Errorf("Objects read by listings: %d", m.gets)
+	}
+
+	if len(blobs) != 2 || blobs[0].Refs != 3 || blobs[1].Refs != 0 {
+		t.Fatalf("Wrong blobs: %+v", blobs)
+	}
+
+	if err := s.DeleteBlob(storage.Blob{Digest: unused}); err != storage.ErrConflict {
+		t.Errorf("Wrong error deleting a blob modified since listed: %v", err)
+	}
+
+	if collected, err := storage.CollectBlobs(s, false, time.Now()); err != nil || len(collected) != 0 {
+		t.Errorf("Blobs collected within the grace period: %+v %v", collected, err)
+	}
+
+	later := time.Now().Add(storage.BlobGrace)
+
+	if collected, err := storage.CollectBlobs(s, false, later); err != nil || len(collected) != 1 || collected[0].Digest != unused {
+		t.Errorf("Wrong collected blobs: %+v %v", collected, err)
+	}
+
+	if _, err := s.GetKey(blobPath + unused); translateError(err) != storage.ErrNotFound {
+		t.Errorf("Blob kept: %v", err)
+	}
+
+	if err := s.DeleteACI(names[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	if blobs, err := s.ListBlobs(); err != nil || len(blobs) != 1 || blobs[0].Refs != 2 {
+		t.Errorf("Wrong blobs: %+v %v", blobs, err)
+	}
+
+	// Pushes store the blobs collected before they reference them again.
+	if err := s.Del(blobPath + digest); err != nil {
+		t.Fatal(err)
+	}
+
+	publish(2, names[1])
+
+	if c := download(names[1]); c != "aci" {
+		t.Errorf("Wrong content: %q", c)
+	}
+}
+
+// fakeServer implements the multipart uploads, copies and multiple deletions
+// s3test lacks in front of it, completed uploads are stored as regular
+// objects.
+type fakeServer struct {
+	backend *s3test.Server
+	proxy   *httputil.ReverseProxy
+
+	mu      sync.Mutex
+	next    int
+	uploads map[string]map[int][]byte
+	// gets counts the objects read.
+	gets int
+}
+
+func newFakeServer(t *testing.T) (*fakeServer, *httptest.Server) {
+	backend, err := s3test.NewServer(nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(backend.URL())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &fakeServer{
+		backend: backend,
+		proxy:   httputil.NewSingleHostReverseProxy(u),
+		uploads: map[string]map[int][]byte{},
+	}
+
+	return m, httptest.NewServer(m)
+}
+
+// newTestStorage returns a storage sending its requests to a fakeServer,
+// large objects are sent in one part.
+func newTestStorage(t *testing.T) (*Storage, *fakeServer, func()) {
+	m, srv := newFakeServer(t)
+
+	s, err := NewStorage(
+		aws.Auth{AccessKey: "access", SecretKey: "secret"},
+		aws.Region{
+			Name:                 "faux-region-1",
+			S3Endpoint:           srv.URL,
+			S3LocationConstraint: true,
+		},
+		"aci-repository",
+		Options{PartSize: largeObjectSize + 1},
+	)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.PutBucket(s3.Private); err != nil {
+		t.Fatal(err)
+	}
+
+	return s, m, func() {
+		srv.Close()
+		m.backend.Quit()
+	}
+}
+
+func (m *fakeServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	id := q.Get("uploadId")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Listings get the bucket itself.
+	if p := strings.Trim(req.URL.Path, "/"); req.Method == "GET" && strings.Contains(p, "/") {
+		m.gets++
+	}
+
+	switch {
+	case req.Method == "POST" && q["uploads"] != nil:
+		m.next++
+		id = strconv.Itoa(m.next)
+		m.uploads[id] = map[int][]byte{}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", id)
+	case req.Method == "PUT" && id != "":
+		n, _ := strconv.Atoi(q.Get("partNumber"))
+		blob, _ := ioutil.ReadAll(req.Body)
+		m.uploads[id][n] = blob
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, md5.Sum(blob)))
+	case req.Method == "POST" && id != "":
+		var c struct {
+			Parts []struct{ PartNumber int } `xml:"Part"`
+		}
+
+		if err := xml.NewDecoder(req.Body).Decode(&c); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		object := []byte{}
+
+		for _, p := range c.Parts {
+			object = append(object, m.uploads[id][p.PartNumber]...)
+		}
+
+		delete(m.uploads, id)
+
+		r, _ := http.NewRequest("PUT", m.backend.URL()+req.URL.Path, bytes.NewReader(object))
+		resp, err := http.DefaultClient.Do(r)
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		resp.Body.Close()
+		fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+	case req.Method == "DELETE" && id != "":
+		delete(m.uploads, id)
+		w.WriteHeader(http.StatusNoContent)
+	case req.Method == "POST" && q["delete"] != nil:
+		var d struct {
+			Keys []string `xml:"Object>Key"`
+		}
+
+		if err := xml.NewDecoder(req.Body).Decode(&d); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		for _, k := range d.Keys {
+			r, _ := http.NewRequest("DELETE", m.backend.URL()+req.URL.Path+k, nil)
+			resp, err := http.DefaultClient.Do(r)
+
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			resp.Body.Close()
+		}
+
+		fmt.Fprint(w, "<DeleteResult></DeleteResult>")
+	case req.Method == "PUT" && req.Header.Get("x-amz-copy-source") != "":
+		resp, err := http.Get(m.backend.URL() + req.Header.Get("x-amz-copy-source"))
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		object, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			w.WriteHeader(resp.StatusCode)
+			w.Write(object)
+			return
+		}
+
+		r, _ := http.NewRequest("PUT", m.backend.URL()+req.URL.Path, bytes.NewReader(object))
+
+		if resp, err = http.DefaultClient.Do(r); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		resp.Body.Close()
+		fmt.Fprint(w, "<CopyObjectResult></CopyObjectResult>")
+	default:
+		m.proxy.ServeHTTP(w, req)
+	}
+}
+
+func TestUploadParts(t *testing.T) {
+	const partSize = 1 << 10
+
+	dir, err := ioutil.TempDir("", "acserver-s3")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	s, m, quit := newTestStorage(t)
+	defer quit()
+
+	// Parts of a few bytes, below what S3 accepts.
+	s.opts.PartSize = partSize
+
+	for _, bufferDir := range []string{"", dir} {
+		s.opts.BufferDir = bufferDir
+
+		for _, size := range []int{0, 10, partSize, 2 * partSize, 2*partSize + partSize/2} {
+			path := fmt.Sprintf("tmp/%q-%d", bufferDir, size)
+			data := make([]byte, size)
+			rand.New(rand.NewSource(int64(size))).Read(data)
+
+			if err := s.upload(path, bytes.NewReader(data)); err != nil {
+				t.Fatalf("%q %d: %v", bufferDir, size, err)
+			}
+
+			if blob, err := s.Get(path); err != nil || !bytes.Equal(blob, data) {
+				t.Errorf("%q %d: wrong object: %d bytes, %v", bufferDir, size, len(blob), err)
+			}
+		}
+	}
+
+	// Bodies of at least a part, for both buffers.
+	if m.next != 6 {
+		t.Errorf("Wrong number of multipart uploads: %d", m.next)
+	}
+
+	if len(m.uploads) != 0 {
+		t.Errorf("Multipart uploads left open: %d", len(m.uploads))
+	}
+
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("Part buffers left behind: %d", len(files))
+	}
+}
+
+func TestPartSize(t *testing.T) {
+	auth := aws.Auth{AccessKey: "access", SecretKey: "secret"}
+
+	if s, err := NewStorage(auth, aws.USEast, "aci-repository", Options{}); err != nil || s.opts.PartSize != DefaultPartSize {
+		t.Errorf("Wrong default part size: %v", err)
+	}
+
+	if _, err := NewStorage(auth, aws.USEast, "aci-repository", Options{PartSize: MinPartSize - 1}); err == nil {
+		t.Errorf("Part size below the minimum accepted")
+	}
+}
+
+func TestListACIsReadsManifests(t *testing.T) {
+	s, _, quit := newTestStorage(t)
+	defer quit()
+
+	name := "example.com/app-my-stable-linux-amd64.aci"
+	manifest := func(n string) {
+		if err := s.Put(
+			aciPath+name+aci.ManifestExt,
+			[]byte(`{"acKind": "ImageManifest", "name": "`+n+`"}`),
+			"application/json",
+			s3.Private,
+		); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.Put(aciPath+name, []byte("aci"), "application/octet-stream", s3.Private); err != nil {
+		t.Fatal(err)
+	}
+
+	digest := "sha512-" + strings.Repeat("ab", 64)
+
+	if err := s.Put(aciPath+name+aci.DigestExt, []byte(digest), "text/plain", s3.Private); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		manifest, name, version string
+	}{
+		{"example.com/app", "example.com/app", "my-stable"},
+		{"example.com/app-my", "example.com/app-my", "stable"},
+	} {
+		manifest(tt.manifest)
+
+		// Listed twice, from the cached manifest the second time.
+		for i := 0; i < 2; i++ {
+			acis, _, err := s.ListACIs()
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(acis) != 1 || acis[0].Name != tt.name || acis[0].Details[0].Version != tt.version || acis[0].Details[0].Digest != digest {
+				t.Errorf("%s: wrong listing: %+v", tt.manifest, acis)
+			}
+		}
+	}
+
+	for _, ext := range []string{"", aci.ManifestExt, aci.DigestExt} {
+		if err := s.Del(aciPath + name + ext); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, _, err := s.ListACIs(); err != nil || len(s.sidecars) != 0 {
+		t.Errorf("Sidecars kept in the cache: %d %v", len(s.sidecars), err)
+	}
+}
@@ -2,7 +2,10 @@ package storage
 
 import (
 	"errors"
+	"fmt"
 	"io"
+	"strings"
+	"time"
 
 	"github.com/appc/acserver/aci"
 	"github.com/appc/acserver/upload"
@@ -10,14 +13,84 @@ import (
 
 var (
 	ErrGPGPubKeyNotProvided = errors.New("GPG Public Key not provided")
+	ErrNotFound             = errors.New("Image not found")
+	ErrConflict             = errors.New("Image conflicts with an existing one")
+	ErrInvalidName          = errors.New("Invalid image name")
+	ErrQuotaExceeded        = errors.New("Storage quota exceeded")
+	ErrUnavailable          = errors.New("Storage backend unavailable")
+	ErrNotSupported         = errors.New("Operation not supported by the storage backend")
 )
 
+type ObjectInfo struct {
+	Size    int64
+	ModTime time.Time
+	Digest  string
+}
+
+type ReadSeekCloser interface {
+	io.ReadSeeker
+	io.Closer
+}
+
 type Storage interface {
 	GetGPGPubKey() ([]byte, error)
-	ListACIs() ([]aci.Aci, error)
-	DownloadACI(string) (io.ReadSeeker, error)
+	ListACIs() ([]aci.Aci, []aci.InvalidFile, error)
+	StatACI(string) (*ObjectInfo, error)
+	DownloadACI(string) (ReadSeekCloser, error)
 	UploadACI(upload.Upload, io.Reader) error
 	UploadASC(upload.Upload, io.Reader) error
+	UploadManifest(upload.Upload, io.Reader) error
 	FinishUpload(upload.Upload) error
 	CancelUpload(upload.Upload) error
+	DeleteACI(string) error
+	YankACI(string, bool) error
+	// TagACI makes the second name serve the same image, signature and
+	// manifest as the first one.
+	TagACI(string, string) error
+}
+
+// MetadataStore is implemented by the backends able to store small documents
+// beside the images, out of their listings. ListMetadata returns the sorted
+// keys starting with a prefix. Decorators return ErrNotSupported when the
+// storage they wrap lacks it.
+type MetadataStore interface {
+	GetMetadata(string) ([]byte, error)
+	PutMetadata(string, []byte) error
+	ListMetadata(string) ([]string, error)
+}
+
+// Presigner is implemented by the backends able to hand out short-lived URLs
+// clients can download from directly, it returns ErrNotSupported when the
+// feature is disabled.
+type Presigner interface {
+	PresignURL(string) (string, error)
+}
+
+// reservedNames can't start a name, the backends keep the uploads being
+// staged and the blobs and their references under them.
+var reservedNames = map[string]bool{"tmp": true, "refs": true, "blobs": true}
+
+// ValidName reports whether a name is safe to store under. Its components
+// can't start with a dot and the first one can't be reserved, the backends
+// keep their own files under such names.
+func ValidName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "/") || strings.ContainsRune(name, 0) {
+		return false
+	}
+
+	if reservedNames[strings.SplitN(name, "/", 2)[0]] {
+		return false
+	}
+
+	for _, token := range strings.Split(name, "/") {
+		if token == "" || strings.HasPrefix(token, ".") {
+			return false
+		}
+	}
+
+	return true
+}
+
+func FormatDigest(sum []byte) string {
+	return fmt.Sprintf("sha512-%x", sum)
 }
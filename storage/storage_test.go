@@ -0,0 +1,26 @@
+package storage
+
+import "testing"
+
+func TestValidName(t *testing.T) {
+	for name, valid := range map[string]bool{
+		"example.com/app-1.0.0-linux-amd64.aci":      true,
+		"example.com/tmp/app-1.0.0-linux-amd64.aci":  true,
+		"tags/example.com/app/stable.json":           true,
+		"audit/2006-01-02.json":                      true,
+		"tmp":                                        false,
+		"tmp/1":                                      false,
+		"refs/example.com/app-1.0.0-linux-amd64.aci": false,
+		"blobs/sha512-ab":                            false,
+		"":                                           false,
+		"/example.com/app":                           false,
+		"example.com//app":                           false,
+		"example.com/../app":                         false,
+		"example.com/.blobs/app":                     false,
+		"example.com/app\x00":                        false,
+	} {
+		if ValidName(name) != valid {
+			t.Errorf("%q: expected valid %v", name, valid)
+		}
+	}
+}
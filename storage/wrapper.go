@@ -0,0 +1,72 @@
+package storage
+
+// Wrapper embeds a storage and forwards the optional interfaces it may
+// implement, failing with ErrNotSupported when it doesn't. The decorators of
+// the storages embed it to only implement the operations they change.
+type Wrapper struct {
+	Storage
+}
+
+func (w Wrapper) PresignURL(n string) (string, error) {
+	if p, ok := w.Storage.(Presigner); ok {
+		return p.PresignURL(n)
+	}
+
+	return "", ErrNotSupported
+}
+
+func (w Wrapper) ListBlobs() ([]Blob, error) {
+	if b, ok := w.Storage.(BlobStore); ok {
+		return b.ListBlobs()
+	}
+
+	return nil, ErrNotSupported
+}
+
+func (w Wrapper) DeleteBlob(blob Blob) error {
+	if b, ok := w.Storage.(BlobStore); ok {
+		return b.DeleteBlob(blob)
+	}
+
+	return ErrNotSupported
+}
+
+func (w Wrapper) DownloadBlob(digest string) (ReadSeekCloser, error) {
+	if b, ok := w.Storage.(BlobStore); ok {
+		return b.DownloadBlob(digest)
+	}
+
+	return nil, ErrNotSupported
+}
+
+func (w Wrapper) IndexesDigests() bool {
+	if i, ok := w.Storage.(DigestIndex); ok {
+		return i.IndexesDigests()
+	}
+
+	return false
+}
+
+func (w Wrapper) GetMetadata(key string) ([]byte, error) {
+	if md, ok := w.Storage.(MetadataStore); ok {
+		return md.GetMetadata(key)
+	}
+
+	return nil, ErrNotSupported
+}
+
+func (w Wrapper) PutMetadata(key string, blob []byte) error {
+	if md, ok := w.Storage.(MetadataStore); ok {
+		return md.PutMetadata(key, blob)
+	}
+
+	return ErrNotSupported
+}
+
+func (w Wrapper) ListMetadata(prefix string) ([]string, error) {
+	if md, ok := w.Storage.(MetadataStore); ok {
+		return md.ListMetadata(prefix)
+	}
+
+	return nil, ErrNotSupported
+}
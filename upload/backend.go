@@ -3,8 +3,10 @@ package upload
 import "errors"
 
 var (
-	ErrEmptyName = errors.New("Empty name")
-	ErrNotFound  = errors.New("Upload not found")
+	ErrEmptyName   = errors.New("Empty name")
+	ErrNotFound    = errors.New("Upload not found")
+	ErrConflict    = errors.New("Upload was modified concurrently")
+	ErrUnavailable = errors.New("Upload backend unavailable")
 )
 
 type Backend interface {
@@ -12,4 +14,6 @@ type Backend interface {
 	Get(uint64) (*Upload, error)
 	Update(*Upload) error
 	Delete(uint64) error
+	// List returns the open uploads ordered by ID.
+	List() ([]*Upload, error)
 }
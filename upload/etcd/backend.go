@@ -3,6 +3,8 @@ package etcd
 import (
 	"encoding/json"
 	"fmt"
+	"path"
+	"sort"
 	"strconv"
 
 	"github.com/appc/acserver/Godeps/_workspace/src/github.com/coreos/etcd/client"
@@ -53,7 +55,7 @@ func (b *Backend) Get(id uint64) (*upload.Upload, error) {
 	)
 
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 
 	up := upload.Upload{}
@@ -66,6 +68,10 @@ func (b *Backend) Get(id uint64) (*upload.Upload, error) {
 }
 
 func (b *Backend) Create(name string) (*upload.Upload, error) {
+	if name == "" {
+		return nil, upload.ErrEmptyName
+	}
+
 	up := upload.NewUpload(name)
 
 	n, err := b.api.Get(
@@ -75,7 +81,7 @@ func (b *Backend) Create(name string) (*upload.Upload, error) {
 	)
 
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 
 	v, err := strconv.Atoi(n.Node.Value)
@@ -92,7 +98,7 @@ func (b *Backend) Create(name string) (*upload.Upload, error) {
 	)
 
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 
 	up.ID = uint64(v + 1)
@@ -110,28 +116,53 @@ func (b *Backend) Create(name string) (*upload.Upload, error) {
 	)
 
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 
 	return up, nil
 }
 
 func (b *Backend) Update(up *upload.Upload) error {
-	blob, err := json.Marshal(up)
+	key := fmt.Sprintf("%s/%d", b.namespace, up.ID)
+	n, err := b.api.Get(context.Background(), key, nil)
+
+	if err != nil {
+		return translateError(err)
+	}
+
+	prev := upload.Upload{}
+
+	if err := json.Unmarshal([]byte(n.Node.Value), &prev); err != nil {
+		return err
+	}
+
+	if err := upload.CheckUpdate(&prev, up); err != nil {
+		return err
+	}
+
+	next := *up
+	next.Revision++
+
+	blob, err := json.Marshal(next)
 
 	if err != nil {
 		return err
 	}
 
-	//TODO: Should compare and swap
 	_, err = b.api.Set(
 		context.Background(),
-		fmt.Sprintf("%s/%d", b.namespace, up.ID),
+		key,
 		string(blob),
-		nil,
+		&client.SetOptions{PrevIndex: n.Node.ModifiedIndex},
 	)
 
-	return err
+	if err != nil {
+		return translateError(err)
+	}
+
+	up.Revision = next.Revision
+
+	return nil
 }
 
 func (b *Backend) Delete(id uint64) error {
@@ -142,5 +173,57 @@ func (b *Backend) Delete(id uint64) error {
 		nil,
 	)
 
+	return translateError(err)
+}
+
+func (b *Backend) List() ([]*upload.Upload, error) {
+	r, err := b.api.Get(context.Background(), b.namespace, nil)
+
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	res := []*upload.Upload{}
+
+	for _, n := range r.Node.Nodes {
+		if path.Base(n.Key) == "counter" {
+			continue
+		}
+
+		up := &upload.Upload{}
+
+		if err := json.Unmarshal([]byte(n.Value), up); err != nil {
+			return nil, err
+		}
+
+		res = append(res, up)
+	}
+
+	sort.Sort(upload.ByID(res))
+
+	return res, nil
+}
+
+func translateError(err error) error {
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case client.Error:
+		switch e.Code {
+		case client.ErrorCodeKeyNotFound:
+			return upload.ErrNotFound
+		case client.ErrorCodeNodeExist, client.ErrorCodeTestFailed:
+			return upload.ErrConflict
+		case client.ErrorCodeRaftInternal, client.ErrorCodeLeaderElect:
+			return upload.ErrUnavailable
+		}
+	case *client.ClusterError:
+		return upload.ErrUnavailable
+	}
+
+	if err == client.ErrNoEndpoints || err == context.DeadlineExceeded {
+		return upload.ErrUnavailable
+	}
+
 	return err
 }
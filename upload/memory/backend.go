@@ -1,6 +1,7 @@
 package memory
 
 import (
+	"sort"
 	"sync"
 
 	"github.com/appc/acserver/upload"
@@ -27,7 +28,7 @@ func (b *Backend) Create(name string) (*upload.Upload, error) {
 	b.counter++
 
 	up.ID = b.counter
-	b.store[up.ID] = up
+	b.store[up.ID] = clone(up)
 
 	return up, nil
 }
@@ -37,7 +38,7 @@ func (b *Backend) Get(id uint64) (*upload.Upload, error) {
 	defer b.mu.Unlock()
 
 	if v, ok := b.store[id]; ok {
-		return v, nil
+		return clone(v), nil
 	}
 
 	return nil, upload.ErrNotFound
@@ -47,13 +48,20 @@ func (b *Backend) Update(up *upload.Upload) error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	if _, ok := b.store[up.ID]; ok {
-		b.store[up.ID] = up
+	prev, ok := b.store[up.ID]
 
-		return nil
+	if !ok {
+		return upload.ErrNotFound
 	}
 
-	return upload.ErrNotFound
+	if err := upload.CheckUpdate(prev, up); err != nil {
+		return err
+	}
+
+	up.Revision++
+	b.store[up.ID] = clone(up)
+
+	return nil
 }
 
 func (b *Backend) Delete(id uint64) error {
@@ -67,3 +75,30 @@ func (b *Backend) Delete(id uint64) error {
 
 	return upload.ErrNotFound
 }
+
+func (b *Backend) List() ([]*upload.Upload, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	res := []*upload.Upload{}
+
+	for _, up := range b.store {
+		res = append(res, clone(up))
+	}
+
+	sort.Sort(upload.ByID(res))
+
+	return res, nil
+}
+
+// clone keeps the callers from modifying the stored uploads.
+func clone(up *upload.Upload) *upload.Upload {
+	c := *up
+	c.Received = make(map[string]int64, len(up.Received))
+
+	for part, n := range up.Received {
+		c.Received[part] = n
+	}
+
+	return &c
+}
@@ -0,0 +1,60 @@
+package upload
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrInvalidTransition = errors.New("Invalid upload state transition")
+
+// State is the step an upload is at: its parts are received, then the
+// upload is verified and published, unless it fails on the way.
+type State string
+
+const (
+	Initiated  State = "initiated"
+	Receiving  State = "receiving"
+	Verifying  State = "verifying"
+	Publishing State = "publishing"
+	Published  State = "published"
+	Failed     State = "failed"
+)
+
+var transitions = map[State][]State{
+	Initiated:  {Receiving, Verifying, Failed},
+	Receiving:  {Receiving, Verifying, Failed},
+	Verifying:  {Publishing, Failed},
+	Publishing: {Published, Failed},
+}
+
+// Done tells whether the upload is over, successfully or not.
+func (s State) Done() bool {
+	return s == Published || s == Failed
+}
+
+// ValidTransition tells whether an upload may go from a state to another,
+// uploads recorded before states existed are considered initiated.
+func ValidTransition(from, to State) bool {
+	if from == "" {
+		from = Initiated
+	}
+
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Transition moves the upload to another state.
+func (u *Upload) Transition(to State) error {
+	if !ValidTransition(u.State, to) {
+		return ErrInvalidTransition
+	}
+
+	u.State, u.Updated = to, time.Now()
+
+	return nil
+}
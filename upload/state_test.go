@@ -0,0 +1,54 @@
+package upload
+
+import "testing"
+
+func TestTransition(t *testing.T) {
+	for _, tt := range []struct {
+		from, to State
+		ok       bool
+	}{
+		{"", Receiving, true},
+		{Initiated, Receiving, true},
+		{Receiving, Receiving, true},
+		{Receiving, Verifying, true},
+		{Initiated, Verifying, true},
+		{Verifying, Verifying, false},
+		{Verifying, Receiving, false},
+		{Verifying, Publishing, true},
+		{Publishing, Published, true},
+		{Publishing, Failed, true},
+		{Published, Failed, false},
+		{Failed, Failed, false},
+		{Failed, Receiving, false},
+		{Receiving, Published, false},
+	} {
+		up := &Upload{State: tt.from}
+		err := up.Transition(tt.to)
+
+		if (err == nil) != tt.ok {
+			t.Errorf("%q -> %q: wrong transition: %v", tt.from, tt.to, err)
+		}
+
+		if err == nil && (up.State != tt.to || up.Updated.IsZero()) {
+			t.Errorf("%q -> %q: state not updated: %+v", tt.from, tt.to, up)
+		}
+	}
+}
+
+func TestCheckUpdate(t *testing.T) {
+	prev := &Upload{State: Receiving, Revision: 3}
+
+	for _, tt := range []struct {
+		next *Upload
+		err  error
+	}{
+		{&Upload{State: Receiving, Revision: 3}, nil},
+		{&Upload{State: Verifying, Revision: 3}, nil},
+		{&Upload{State: Verifying, Revision: 2}, ErrConflict},
+		{&Upload{State: Published, Revision: 3}, ErrInvalidTransition},
+	} {
+		if err := CheckUpdate(prev, tt.next); err != tt.err {
+			t.Errorf("%+v: wrong error: %v", tt.next, err)
+		}
+	}
+}
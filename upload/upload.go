@@ -6,11 +6,57 @@ type Upload struct {
 	ID      uint64
 	Started time.Time
 	Image   string
-	GotSig  bool
-	GotACI  bool
-	GotMan  bool
+	// Uploader is the principal who started the upload, if it was
+	// authenticated.
+	Uploader string
+	GotSig   bool
+	GotACI   bool
+	GotMan   bool
+	// Received holds the number of bytes received for each part.
+	Received map[string]int64
+	// Digest is the SHA-512 of the ACI received, the image ID.
+	Digest string
+
+	State   State
+	Updated time.Time
+	// Reason and ServerReason explain why a failed upload failed, as given
+	// by the client and the server.
+	Reason       string
+	ServerReason string
+
+	// Revision is incremented by the backends on every update, which fail
+	// if the upload was updated since it was read.
+	Revision uint64
 }
 
 func NewUpload(name string) *Upload {
-	return &Upload{Started: time.Now(), Image: name}
+	now := time.Now()
+
+	return &Upload{
+		Started:  now,
+		Image:    name,
+		Received: make(map[string]int64),
+		State:    Initiated,
+		Updated:  now,
+	}
 }
+
+// CheckUpdate returns the error a backend fails with when updating an upload
+// recorded as prev with next.
+func CheckUpdate(prev, next *Upload) error {
+	if prev.Revision != next.Revision {
+		return ErrConflict
+	}
+
+	if prev.State != next.State && !ValidTransition(prev.State, next.State) {
+		return ErrInvalidTransition
+	}
+
+	return nil
+}
+
+type ByID []*Upload
+
+func (u ByID) Len() int           { return len(u) }
+func (u ByID) Swap(i, j int)      { u[i], u[j] = u[j], u[i] }
+func (u ByID) Less(i, j int) bool { return u[i].ID < u[j].ID }